@@ -0,0 +1,279 @@
+// Package a2s queries game servers with Steam's A2S_INFO and A2S_PLAYER protocol.
+// See https://developer.valvesoftware.com/wiki/Server_queries
+package a2s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	headerSingle = -1 // 0xFFFFFFFF, the whole response is in one packet
+	headerSplit  = -2 // 0xFFFFFFFE, the response is split over several packets
+
+	requestInfo    = 'T'
+	requestPlayer  = 'U'
+	responseInfo   = 'I'
+	responsePlayer = 'D'
+	challenge      = 'A'
+
+	maxSplits = 32 // more than any real response needs
+)
+
+var ErrInvalidResponse = errors.New("a2s: invalid response from server")
+
+// Info is a server's A2S_INFO response. Fields only sent by some servers are left at zero.
+type Info struct {
+	Protocol    byte
+	Name        string
+	Map         string
+	Folder      string
+	Game        string
+	AppID       uint16
+	Players     int
+	MaxPlayers  int
+	Bots        int
+	ServerType  string // "dedicated", "listen" or "proxy"
+	Environment string // "linux", "windows" or "mac"
+	Password    bool
+	VAC         bool
+	Version     string
+	Port        uint16
+	SteamID     uint64
+	Keywords    string
+	GameID      uint64
+}
+
+// Player is one player from a server's A2S_PLAYER response.
+type Player struct {
+	Name     string
+	Score    int32
+	Duration time.Duration // how long they've been connected
+}
+
+// QueryInfo asks the server at address ("host:port", the query port) for its info.
+func QueryInfo(address string, timeout time.Duration) (Info, error) {
+	data, err := query(address, timeout, append([]byte{requestInfo}, "Source Engine Query\x00"...), responseInfo)
+	if err != nil {
+		return Info{}, err
+	}
+	return parseInfo(data)
+}
+
+// QueryPlayers asks the server at address ("host:port", the query port) who's online.
+func QueryPlayers(address string, timeout time.Duration) ([]Player, error) {
+	data, err := query(address, timeout, []byte{requestPlayer, 0xFF, 0xFF, 0xFF, 0xFF}, responsePlayer)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlayers(data)
+}
+
+// query sends the request, answering a challenge if the server sends one, and returns the response after its type.
+func query(address string, timeout time.Duration, request []byte, responseType byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// a server can keep asking for a challenge, but more than a couple of times means something's wrong
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(append([]byte{0xFF, 0xFF, 0xFF, 0xFF}, request...)); err != nil {
+			return nil, err
+		}
+		response, err := readResponse(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(response) == 0 {
+			return nil, ErrInvalidResponse
+		}
+		switch response[0] {
+		case responseType:
+			return response[1:], nil
+		case challenge:
+			if len(response) < 5 {
+				return nil, ErrInvalidResponse
+			}
+			// info takes the challenge on the end, players in place of the placeholder
+			if request[0] == requestInfo {
+				request = append(request[:len("Source Engine Query")+2], response[1:5]...)
+			} else {
+				request = append([]byte{request[0]}, response[1:5]...)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected response type %q", ErrInvalidResponse, response[0])
+		}
+	}
+	return nil, fmt.Errorf("%w: too many challenges", ErrInvalidResponse)
+}
+
+// readResponse reads a whole response, putting split packets back together, and returns it without its header.
+func readResponse(conn net.Conn) ([]byte, error) {
+	buffer := make([]byte, 65536)
+	var parts [][]byte
+	var id int32
+	received := 0
+
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		r := newReader(buffer[:n])
+		switch header := r.int32(); header {
+		case headerSingle:
+			return r.rest(), r.err
+		case headerSplit:
+			packetID, total, number := r.int32(), int(r.byte()), int(r.byte())
+			if packetID < 0 {
+				return nil, fmt.Errorf("%w: compressed responses aren't supported", ErrInvalidResponse)
+			}
+			r.int16() // max packet size, source only. goldsrc packs total and number into one byte, which isn't supported
+			if r.err != nil || total == 0 || total > maxSplits || number >= total {
+				return nil, ErrInvalidResponse
+			}
+			if parts == nil {
+				parts, id = make([][]byte, total), packetID
+			}
+			if packetID != id || len(parts) != total {
+				continue // from an earlier response
+			}
+			if parts[number] == nil {
+				parts[number] = append([]byte{}, r.rest()...)
+				received++
+			}
+			if received < total {
+				continue
+			}
+			whole := bytes.Join(parts, nil)
+			inner := newReader(whole)
+			if inner.int32() != headerSingle {
+				return nil, ErrInvalidResponse
+			}
+			return inner.rest(), inner.err
+		default:
+			return nil, fmt.Errorf("%w: unknown header %d", ErrInvalidResponse, header)
+		}
+	}
+}
+
+func parseInfo(data []byte) (Info, error) {
+	r := newReader(data)
+	info := Info{
+		Protocol:   r.byte(),
+		Name:       r.string(),
+		Map:        r.string(),
+		Folder:     r.string(),
+		Game:       r.string(),
+		AppID:      uint16(r.int16()),
+		Players:    int(r.byte()),
+		MaxPlayers: int(r.byte()),
+		Bots:       int(r.byte()),
+	}
+	info.ServerType = map[byte]string{'d': "dedicated", 'l': "listen", 'p': "proxy"}[r.byte()]
+	info.Environment = map[byte]string{'l': "linux", 'w': "windows", 'm': "mac", 'o': "mac"}[r.byte()]
+	info.Password = r.byte() == 1
+	info.VAC = r.byte() == 1
+	if info.AppID == 2400 {
+		r.byte() // the ship's mode, witnesses and duration
+		r.byte()
+		r.byte()
+	}
+	info.Version = r.string()
+	if r.err != nil {
+		return Info{}, r.err
+	}
+
+	// optional extra data, flagged in one byte
+	if r.remaining() == 0 {
+		return info, nil
+	}
+	flags := r.byte()
+	if flags&0x80 != 0 {
+		info.Port = uint16(r.int16())
+	}
+	if flags&0x10 != 0 {
+		info.SteamID = r.uint64()
+	}
+	if flags&0x40 != 0 {
+		r.int16() // sourcetv port and name
+		r.string()
+	}
+	if flags&0x20 != 0 {
+		info.Keywords = r.string()
+	}
+	if flags&0x01 != 0 {
+		info.GameID = r.uint64()
+	}
+	return info, r.err
+}
+
+func parsePlayers(data []byte) ([]Player, error) {
+	r := newReader(data)
+	count := int(r.byte())
+	players := make([]Player, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		r.byte() // index, always 0 on most servers
+		player := Player{Name: r.string(), Score: r.int32()}
+		seconds := math.Float32frombits(uint32(r.int32()))
+		player.Duration = time.Duration(float64(seconds) * float64(time.Second))
+		players = append(players, player)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return players, nil
+}
+
+// reader reads little endian values from a packet, remembering the first error so it only has to be checked once.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func newReader(data []byte) *reader {
+	return &reader{data: data}
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("%w: response is too short", ErrInvalidResponse)
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) byte() byte     { return r.take(1)[0] }
+func (r *reader) int16() int16   { return int16(binary.LittleEndian.Uint16(r.take(2))) }
+func (r *reader) int32() int32   { return int32(binary.LittleEndian.Uint32(r.take(4))) }
+func (r *reader) uint64() uint64 { return binary.LittleEndian.Uint64(r.take(8)) }
+func (r *reader) remaining() int { return len(r.data) }
+func (r *reader) rest() []byte   { return r.take(len(r.data)) }
+
+// string reads a null terminated string.
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.data, 0)
+	if i == -1 {
+		r.err = fmt.Errorf("%w: unterminated string", ErrInvalidResponse)
+		return ""
+	}
+	s := string(r.data[:i])
+	r.data = r.data[i+1:]
+	return s
+}
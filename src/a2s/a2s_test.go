@@ -0,0 +1,338 @@
+package a2s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers each UDP request with whatever packets respond returns, and keeps the requests it got.
+type fakeServer struct {
+	conn     net.PacketConn
+	respond  func(request []byte) [][]byte
+	mutex    sync.Mutex
+	requests [][]byte
+}
+
+func startFakeServer(t *testing.T, respond func(request []byte) [][]byte) *fakeServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{conn: conn, respond: respond}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 1400)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			request := append([]byte{}, buffer[:n]...)
+			server.mutex.Lock()
+			server.requests = append(server.requests, request)
+			server.mutex.Unlock()
+			for _, packet := range respond(request) {
+				conn.WriteTo(packet, addr)
+			}
+		}
+	}()
+	return server
+}
+
+func (s *fakeServer) address() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *fakeServer) getRequests() [][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests
+}
+
+// packet builds a little endian packet out of bytes, strings (null terminated), and fixed size ints.
+func packet(values ...any) []byte {
+	var b bytes.Buffer
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			b.WriteString(v)
+			b.WriteByte(0)
+		case []byte:
+			b.Write(v)
+		default:
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+	}
+	return b.Bytes()
+}
+
+func single(values ...any) []byte {
+	return packet(append([]any{int32(headerSingle)}, values...)...)
+}
+
+// split splits a single packet response into parts of at most size bytes, each with a source split header.
+func split(id int32, whole []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(whole) > size {
+		chunks = append(chunks, whole[:size])
+		whole = whole[size:]
+	}
+	chunks = append(chunks, whole)
+	parts := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = packet(int32(headerSplit), id, byte(len(chunks)), byte(i), int16(1248), chunk)
+	}
+	return parts
+}
+
+var (
+	infoRequest    = packet([]byte{0xFF, 0xFF, 0xFF, 0xFF, requestInfo}, "Source Engine Query")
+	playersRequest = []byte{0xFF, 0xFF, 0xFF, 0xFF, requestPlayer, 0xFF, 0xFF, 0xFF, 0xFF}
+	challengeBytes = []byte{0x12, 0x34, 0x56, 0x78}
+)
+
+// infoPayload is an A2S_INFO response for a linux dedicated server without the extra data, extra is appended.
+func infoPayload(extra ...any) []byte {
+	values := []any{
+		byte(responseInfo), byte(17), "My Server", "de_dust2", "cstrike", "Counter-Strike", uint16(240),
+		byte(5), byte(16), byte(1), byte('d'), byte('l'), byte(1), byte(1), "1.0.0.70",
+	}
+	return single(append(values, extra...)...)
+}
+
+func playersPayload() []byte {
+	return single(
+		byte(responsePlayer), byte(2),
+		byte(0), "alice", int32(10), math.Float32bits(90.5),
+		byte(0), "bob", int32(-2), math.Float32bits(3),
+	)
+}
+
+func checkPlayers(t *testing.T, players []Player) {
+	t.Helper()
+	want := []Player{
+		{Name: "alice", Score: 10, Duration: 90500 * time.Millisecond},
+		{Name: "bob", Score: -2, Duration: 3 * time.Second},
+	}
+	if len(players) != len(want) {
+		t.Fatalf("got %d players, want %d: %+v", len(players), len(want), players)
+	}
+	for i := range want {
+		if players[i] != want[i] {
+			t.Fatalf("player %d is %+v, want %+v", i, players[i], want[i])
+		}
+	}
+}
+
+func TestQueryInfo(t *testing.T) {
+	server := startFakeServer(t, func(request []byte) [][]byte {
+		return [][]byte{infoPayload()}
+	})
+	info, err := QueryInfo(server.address(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{
+		Protocol: 17, Name: "My Server", Map: "de_dust2", Folder: "cstrike", Game: "Counter-Strike", AppID: 240,
+		Players: 5, MaxPlayers: 16, Bots: 1, ServerType: "dedicated", Environment: "linux", Password: true, VAC: true,
+		Version: "1.0.0.70",
+	}
+	if info != want {
+		t.Fatalf("got %+v\nwant %+v", info, want)
+	}
+	if requests := server.getRequests(); len(requests) != 1 || !bytes.Equal(requests[0], infoRequest) {
+		t.Fatalf("unexpected requests %q", requests)
+	}
+}
+
+func TestQueryInfoExtraData(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra []any
+		want  Info
+	}{
+		{name: "none", extra: nil},
+		{name: "port", extra: []any{byte(0x80), uint16(27015)}, want: Info{Port: 27015}},
+		{name: "steam id", extra: []any{byte(0x10), uint64(90071992547409920)}, want: Info{SteamID: 90071992547409920}},
+		{name: "keywords", extra: []any{byte(0x20), "pvp,hardcore"}, want: Info{Keywords: "pvp,hardcore"}},
+		{name: "game id", extra: []any{byte(0x01), uint64(240)}, want: Info{GameID: 240}},
+		{name: "sourcetv is skipped", extra: []any{byte(0x40), uint16(27020), "tv"}},
+		{
+			name: "everything",
+			extra: []any{
+				byte(0x80 | 0x10 | 0x40 | 0x20 | 0x01), uint16(27015), uint64(90071992547409920), uint16(27020), "tv",
+				"pvp", uint64(240),
+			},
+			want: Info{Port: 27015, SteamID: 90071992547409920, Keywords: "pvp", GameID: 240},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, func(request []byte) [][]byte {
+				return [][]byte{infoPayload(test.extra...)}
+			})
+			info, err := QueryInfo(server.address(), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name != "My Server" || info.Version != "1.0.0.70" {
+				t.Fatalf("the standard fields are wrong: %+v", info)
+			}
+			if info.Port != test.want.Port || info.SteamID != test.want.SteamID || info.Keywords != test.want.Keywords || info.GameID != test.want.GameID {
+				t.Fatalf("got port %d, steam id %d, keywords %q, game id %d, want %+v",
+					info.Port, info.SteamID, info.Keywords, info.GameID, test.want)
+			}
+		})
+	}
+}
+
+// challenging responds with a challenge until the request ends with it, then with response.
+func challenging(response []byte) func(request []byte) [][]byte {
+	return func(request []byte) [][]byte {
+		if !bytes.HasSuffix(request, challengeBytes) {
+			return [][]byte{single(byte(challenge), challengeBytes)}
+		}
+		return [][]byte{response}
+	}
+}
+
+func TestQueryInfoChallenge(t *testing.T) {
+	server := startFakeServer(t, challenging(infoPayload()))
+	info, err := QueryInfo(server.address(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "My Server" {
+		t.Fatalf("got %+v", info)
+	}
+	// the challenge goes on the end of the info request
+	requests := server.getRequests()
+	if len(requests) != 2 || !bytes.Equal(requests[0], infoRequest) || !bytes.Equal(requests[1], append(infoRequest, challengeBytes...)) {
+		t.Fatalf("unexpected requests %q", requests)
+	}
+}
+
+func TestQueryPlayersChallenge(t *testing.T) {
+	server := startFakeServer(t, challenging(playersPayload()))
+	players, err := QueryPlayers(server.address(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlayers(t, players)
+	// the challenge replaces the placeholder
+	want := append([]byte{0xFF, 0xFF, 0xFF, 0xFF, requestPlayer}, challengeBytes...)
+	requests := server.getRequests()
+	if len(requests) != 2 || !bytes.Equal(requests[0], playersRequest) || !bytes.Equal(requests[1], want) {
+		t.Fatalf("unexpected requests %q", requests)
+	}
+}
+
+func TestQueryPlayersSplit(t *testing.T) {
+	parts := split(7, playersPayload(), 12)
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d", len(parts))
+	}
+	stale := split(6, playersPayload(), 12)
+	tests := []struct {
+		name    string
+		packets [][]byte
+	}{
+		{name: "in order", packets: parts},
+		{name: "out of order", packets: [][]byte{parts[2], parts[0], parts[1]}},
+		{name: "duplicates", packets: [][]byte{parts[1], parts[1], parts[0], parts[1], parts[2]}},
+		{name: "parts of another response", packets: [][]byte{parts[0], stale[1], parts[2], stale[0], parts[1]}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, func(request []byte) [][]byte {
+				return test.packets
+			})
+			players, err := QueryPlayers(server.address(), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPlayers(t, players)
+		})
+	}
+}
+
+func TestQueryInfoSplit(t *testing.T) {
+	// backwards, with the last part sent twice
+	parts := split(1, infoPayload(byte(0x20), "pvp"), 16)
+	packets := [][]byte{parts[len(parts)-1]}
+	for i := len(parts) - 1; i >= 0; i-- {
+		packets = append(packets, parts[i])
+	}
+	server := startFakeServer(t, func(request []byte) [][]byte {
+		return packets
+	})
+	info, err := QueryInfo(server.address(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "My Server" || info.Version != "1.0.0.70" || info.Keywords != "pvp" {
+		t.Fatalf("got %+v", info)
+	}
+}
+
+func TestInvalidResponses(t *testing.T) {
+	info := infoPayload()
+	players := playersPayload()
+	tests := []struct {
+		name    string
+		players bool
+		packets [][]byte
+	}{
+		{name: "shorter than the header", packets: [][]byte{{0xFF, 0xFF}}},
+		{name: "unknown header", packets: [][]byte{packet(int32(5), byte(responseInfo))}},
+		{name: "empty response", packets: [][]byte{single()}},
+		{name: "wrong response type", packets: [][]byte{single(byte('X'))}},
+		{name: "info cut off in a string", packets: [][]byte{info[:12]}},
+		{name: "info cut off before the version", packets: [][]byte{info[:len(info)-10]}},
+		{name: "info missing the version's terminator", packets: [][]byte{info[:len(info)-1]}},
+		{name: "info cut off in the extra data", packets: [][]byte{infoPayload(byte(0x10), uint32(1))}},
+		{name: "players cut off", players: true, packets: [][]byte{players[:len(players)-2]}},
+		{name: "players count too high", players: true, packets: [][]byte{single(byte(responsePlayer), byte(3))}},
+		{name: "challenge cut off", packets: [][]byte{single(byte(challenge), byte(1), byte(2))}},
+		{name: "split header cut off", packets: [][]byte{packet(int32(headerSplit), int32(1), byte(2))}},
+		{name: "split part number past the total", packets: [][]byte{packet(int32(headerSplit), int32(1), byte(2), byte(2), int16(1248), info)}},
+		{name: "split into too many parts", packets: [][]byte{packet(int32(headerSplit), int32(1), byte(maxSplits+1), byte(0), int16(1248), info)}},
+		{name: "compressed split", packets: [][]byte{packet(int32(headerSplit), int32(-1), byte(1), byte(0), int16(1248), info)}},
+		{name: "split without a single header inside", packets: [][]byte{packet(int32(headerSplit), int32(1), byte(1), byte(0), int16(1248), info[4:])}},
+		{name: "too many challenges", packets: [][]byte{single(byte(challenge), challengeBytes)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, func(request []byte) [][]byte {
+				return test.packets
+			})
+			var err error
+			if test.players {
+				_, err = QueryPlayers(server.address(), time.Second)
+			} else {
+				_, err = QueryInfo(server.address(), time.Second)
+			}
+			if !errors.Is(err, ErrInvalidResponse) {
+				t.Fatalf("got %v, want ErrInvalidResponse", err)
+			}
+		})
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	server := startFakeServer(t, func(request []byte) [][]byte { return nil })
+	start := time.Now()
+	if _, err := QueryInfo(server.address(), 200*time.Millisecond); err == nil {
+		t.Fatal("expected an error when the server doesn't answer")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("took %s to time out", elapsed)
+	}
+}
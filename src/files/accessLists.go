@@ -0,0 +1,247 @@
+package files
+
+// files/accessLists.go is for the player lists games keep in files, like whitelists, ban lists and admin lists. Which
+// lists there are and how they're stored comes from "access_lists", usually filled in by a preset.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	AccessListLines       = "lines"        // one player per line, lines starting with # or // are comments
+	AccessListJSON        = "json"         // a JSON array of names
+	AccessListJSONObjects = "json_objects" // a JSON array of objects with a "name", their other keys are kept
+)
+
+var (
+	ErrAccessListNotFound = errors.New("access list not found")
+	ErrInvalidPlayerName  = errors.New("player names can't be empty or contain spaces")
+	ErrPlayerListed       = errors.New("that player is already on the list")
+	ErrPlayerNotListed    = errors.New("that player isn't on the list")
+
+	accessListsMutex sync.Mutex
+)
+
+// InitAccessLists makes sure the configured access lists make sense.
+func InitAccessLists() {
+	seen := map[string]bool{}
+	for _, list := range Config.AccessLists {
+		if list.Name == "" || list.Path == "" {
+			panic("access lists need a name and a path")
+		}
+		if seen[list.Name] {
+			panic(fmt.Sprintf("there's more than one access list called %q", list.Name))
+		}
+		seen[list.Name] = true
+		switch list.Format {
+		case "", AccessListLines, AccessListJSON, AccessListJSONObjects:
+		default:
+			panic(fmt.Sprintf("access list %s has an invalid format %q", list.Name, list.Format))
+		}
+	}
+}
+
+// GetAccessList returns the configured list with the given name.
+func GetAccessList(name string) (AccessList, error) {
+	for _, list := range Config.AccessLists {
+		if list.Name == name {
+			return list, nil
+		}
+	}
+	return AccessList{}, ErrAccessListNotFound
+}
+
+// CheckPlayerName makes sure a name is safe to put in a list file or a console command.
+func CheckPlayerName(name string) error {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) != -1 {
+		return ErrInvalidPlayerName
+	}
+	if strings.HasPrefix(name, "#") || strings.HasPrefix(name, "//") {
+		return ErrInvalidPlayerName // would be a comment in a lines file
+	}
+	return nil
+}
+
+// ReadAccessList returns the players on a list. A missing file is an empty list.
+func ReadAccessList(list AccessList) ([]string, error) {
+	accessListsMutex.Lock()
+	defer accessListsMutex.Unlock()
+	entries, err := readAccessList(list)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.name != "" {
+			names = append(names, entry.name)
+		}
+	}
+	return names, nil
+}
+
+// AddToAccessList adds a player to a list's file.
+func AddToAccessList(list AccessList, name string) error {
+	if err := CheckPlayerName(name); err != nil {
+		return err
+	}
+	accessListsMutex.Lock()
+	defer accessListsMutex.Unlock()
+	entries, err := readAccessList(list)
+	if err != nil {
+		return err
+	}
+	if findAccessListEntry(entries, name) != -1 {
+		return ErrPlayerListed
+	}
+	entry := accessListEntry{name: name, raw: []byte(name)}
+	switch list.Format {
+	case AccessListJSON:
+		entry.raw, err = json.Marshal(name)
+	case AccessListJSONObjects:
+		entry.raw, err = json.Marshal(map[string]string{"name": name})
+	}
+	if err != nil {
+		return err
+	}
+	return writeAccessList(list, append(entries, entry))
+}
+
+// RemoveFromAccessList removes a player from a list's file.
+func RemoveFromAccessList(list AccessList, name string) error {
+	accessListsMutex.Lock()
+	defer accessListsMutex.Unlock()
+	entries, err := readAccessList(list)
+	if err != nil {
+		return err
+	}
+	i := findAccessListEntry(entries, name)
+	if i == -1 {
+		return ErrPlayerNotListed
+	}
+	return writeAccessList(list, append(entries[:i], entries[i+1:]...))
+}
+
+// accessListEntry is a line or JSON value from a list file. Comments and blank lines have no name but are kept.
+type accessListEntry struct {
+	name string
+	raw  []byte
+}
+
+// findAccessListEntry returns the index of the player's entry, or -1. Names are matched ignoring case since most
+// games do.
+func findAccessListEntry(entries []accessListEntry, name string) int {
+	for i, entry := range entries {
+		if entry.name != "" && strings.EqualFold(entry.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func readAccessList(list AccessList) ([]accessListEntry, error) {
+	data, err := os.ReadFile(list.Path)
+	if os.IsNotExist(err) {
+		return []accessListEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []accessListEntry{}
+	if list.Format == "" || list.Format == AccessListLines {
+		text := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		if text == "" {
+			return entries, nil
+		}
+		for _, line := range strings.Split(text, "\n") {
+			entry := accessListEntry{raw: []byte(line)}
+			trimmed := strings.TrimSpace(line)
+			if !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "//") {
+				entry.name = trimmed
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	// some games write an empty file before anything's been added
+	if len(bytes.TrimSpace(data)) == 0 {
+		return entries, nil
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%s isn't a JSON array: %w", filepath.Base(list.Path), err)
+	}
+	for _, value := range values {
+		entry := accessListEntry{raw: value}
+		// a plain name, or an object with one. Factorio's ban list uses "username"
+		var object struct {
+			Name     string `json:"name"`
+			Username string `json:"username"`
+		}
+		if json.Unmarshal(value, &entry.name) != nil && json.Unmarshal(value, &object) == nil {
+			entry.name = object.Name
+			if entry.name == "" {
+				entry.name = object.Username
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeAccessList replaces a list's file, through a temp file so the game never sees it half written.
+func writeAccessList(list AccessList, entries []accessListEntry) error {
+	var data []byte
+	if list.Format == "" || list.Format == AccessListLines {
+		lines := make([]string, len(entries))
+		for i, entry := range entries {
+			lines[i] = string(entry.raw)
+		}
+		if len(lines) > 0 {
+			data = []byte(strings.Join(lines, "\n") + "\n")
+		}
+	} else {
+		values := make([]json.RawMessage, len(entries))
+		for i, entry := range entries {
+			values[i] = entry.raw
+		}
+		var err error
+		if data, err = json.MarshalIndent(values, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(list.Path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(list.Path), ".tsm-list-*")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), list.Path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return nil
+}
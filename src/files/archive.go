@@ -0,0 +1,146 @@
+package files
+
+// files/archive.go is for looking inside backup archives without fully extracting them.
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrEntryNotFound = errors.New("entry not found in backup")
+
+// ArchiveEntry describes a single file, directory or symlink inside a backup archive.
+type ArchiveEntry struct {
+	Path       string      `json:"path"`
+	Size       int64       `json:"size"`
+	ModTime    time.Time   `json:"mtime"`
+	Mode       os.FileMode `json:"mode"`
+	IsDir      bool        `json:"isDir"`
+	LinkTarget string      `json:"linkTarget,omitempty"`
+}
+
+// cleanEntryName normalizes an archive entry name, e.g. "slot0//players/a.dat" -> "slot0/players/a.dat"
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// isInSubtree returns true if name is root or is inside the root directory
+func isInSubtree(name, root string) bool {
+	return name == root || strings.HasPrefix(name, root+"/")
+}
+
+// safeJoin joins an archive entry name onto dir, refusing names that would escape it.
+func safeJoin(dir, name string) (string, error) {
+	joined := filepath.Join(dir, filepath.FromSlash(cleanEntryName(name)))
+	rel, err := filepath.Rel(dir, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("illegal entry path: " + name)
+	}
+	return joined, nil
+}
+
+// findEntry returns the entry at entryPath in the backup, or ErrEntryNotFound.
+func findEntry(backup Backup, entryPath string) (ArchiveEntry, error) {
+	var found *ArchiveEntry
+	err := walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if entry.Path == entryPath {
+			found = &entry
+		}
+		return nil
+	})
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	if found == nil {
+		return ArchiveEntry{}, ErrEntryNotFound
+	}
+	return *found, nil
+}
+
+// ListBackupEntries returns every entry inside the backup's archive.
+func ListBackupEntries(backup Backup) ([]ArchiveEntry, error) {
+	entries := []ArchiveEntry{}
+	err := walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// SendBackupEntryToClient streams a single entry from the backup to the client.
+// Directories are sent as a zip of their contents.
+func SendBackupEntryToClient(w http.ResponseWriter, backup Backup, entryPath string) error {
+	entryPath = cleanEntryName(entryPath)
+	if entryPath == "" {
+		return ErrEntryNotFound
+	}
+	target, err := findEntry(backup, entryPath)
+	if err != nil {
+		return err
+	}
+
+	// single file, stream it as is
+	if !target.IsDir {
+		w.Header().Set("Content-Disposition", "attachment; filename="+path.Base(entryPath))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		return walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+			if entry.Path != entryPath || r == nil {
+				return nil
+			}
+			_, err := io.Copy(w, r)
+			return err
+		})
+	}
+
+	// directory, zip the subtree on the fly
+	w.Header().Set("Content-Disposition", "attachment; filename="+path.Base(entryPath)+".zip")
+	w.Header().Set("Content-Type", "application/zip")
+	aw, err := zipArchiver{}.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	err = walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if !isInSubtree(entry.Path, entryPath) {
+			return nil
+		}
+		return aw.WriteEntry(entry, r)
+	})
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// RestoreBackupEntry replaces the live copy of entryPath (and everything under it) with the copy from the backup.
+// Assumes server is stopped.
+func RestoreBackupEntry(backup Backup, entryPath string) error {
+	entryPath = cleanEntryName(entryPath)
+	if entryPath == "" || entryPath == manifestName {
+		return ErrEntryNotFound
+	}
+
+	// make sure the entry exists before touching anything
+	if _, err := findEntry(backup, entryPath); err != nil {
+		return err
+	}
+	manifest, err := readManifest(backup)
+	if err != nil {
+		return err
+	}
+
+	// clean the live copy
+	if err := clearEntry(manifest, entryPath); err != nil {
+		return err
+	}
+
+	// extract the subtree
+	return extractBackup(backup, resolverFor(manifest), func(name string) bool {
+		return isInSubtree(name, entryPath)
+	}, nil)
+}
@@ -0,0 +1,272 @@
+package files
+
+// files/archiver.go is the format independent side of creating and extracting backup archives.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FormatZip    = "zip"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+// Archiver is implemented by each supported backup archive format.
+type Archiver interface {
+	// Extension is the file extension for archives of this format, without the leading dot.
+	Extension() string
+	// NewWriter returns a writer that adds entries to a new archive written to w.
+	NewWriter(w io.Writer) (ArchiveWriter, error)
+	// Walk calls fn for every entry in the archive read from r, in archive order.
+	Walk(r io.Reader, fn WalkFunc) error
+}
+
+// ArchiveWriter adds entries to an archive. Close must be called to finish the archive.
+type ArchiveWriter interface {
+	// WriteEntry adds an entry, r is read for regular files and ignored otherwise.
+	WriteEntry(entry ArchiveEntry, r io.Reader) error
+	Close() error
+}
+
+// WalkFunc is called for each archive entry. r holds the contents of regular files, is nil otherwise,
+// and is only valid for the duration of the call.
+type WalkFunc func(entry ArchiveEntry, r io.Reader) error
+
+// GetArchiver returns the archiver for the given format, an empty format is treated as zip for older backups.
+func GetArchiver(format string) (Archiver, error) {
+	switch format {
+	case "", FormatZip:
+		return zipArchiver{}, nil
+	case FormatTarGz:
+		return tarArchiver{compression: FormatTarGz}, nil
+	case FormatTarZst:
+		return tarArchiver{compression: FormatTarZst}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// entryFromFileInfo builds an archive entry for the file at path, reading the link target of symlinks.
+func entryFromFileInfo(name, path string, info os.FileInfo) (ArchiveEntry, error) {
+	entry := ArchiveEntry{
+		Path:    name,
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		IsDir:   info.IsDir(),
+	}
+	if info.Mode().IsRegular() {
+		entry.Size = info.Size()
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return entry, err
+		}
+		entry.LinkTarget = target
+	}
+	return entry, nil
+}
+
+// writeFileEntry adds a single file, directory or symlink to the archive.
+func writeFileEntry(aw ArchiveWriter, entry ArchiveEntry, path string) error {
+	if !entry.Mode.IsRegular() {
+		return aw.WriteEntry(entry, nil)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return aw.WriteEntry(entry, file)
+}
+
+// walkBackup calls fn for every entry in the backup's archive, decrypting it if needed.
+func walkBackup(backup Backup, fn WalkFunc) error {
+	archiver, err := GetArchiver(backup.Format)
+	if err != nil {
+		return err
+	}
+	reader, err := openBackup(backup)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return archiver.Walk(reader, fn)
+}
+
+// extractBackup extracts every entry accepted by filter (nil for all) to wherever resolve maps it,
+// restoring modes, mtimes and symlinks. Symlinks are created last so the archive can't use them to write
+// outside of where entries resolve to.
+func extractBackup(backup Backup, resolve entryResolver, filter func(name string) bool, progress Progress) error {
+	progress = orNop(progress)
+	type resolvedEntry struct {
+		entry   ArchiveEntry
+		outPath string
+	}
+	var links []resolvedEntry
+	var dirs []resolvedEntry
+
+	err := walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if filter != nil && !filter(entry.Path) {
+			return nil
+		}
+		outPath, err := resolve(entry.Path)
+		if err != nil {
+			return err
+		}
+		if outPath == "" {
+			return nil
+		}
+
+		switch {
+		case entry.IsDir:
+			dirs = append(dirs, resolvedEntry{entry, outPath})
+			return os.MkdirAll(outPath, os.ModePerm)
+		case entry.Mode&os.ModeSymlink != 0:
+			links = append(links, resolvedEntry{entry, outPath})
+			return nil
+		case !entry.Mode.IsRegular() || r == nil:
+			return nil // devices, pipes, etc. are never archived, ignore them if present
+		}
+
+		if err := os.MkdirAll(filepath.Dir(outPath), os.ModePerm); err != nil {
+			return err
+		}
+		outFile, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(outFile, r); err != nil {
+			outFile.Close()
+			return err
+		}
+		if err := outFile.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(outPath, entry.Mode.Perm()); err != nil {
+			return err
+		}
+		progress.Advance(entry.Size, 1)
+		return os.Chtimes(outPath, entry.ModTime, entry.ModTime)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if err := os.MkdirAll(filepath.Dir(link.outPath), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(link.outPath); err != nil {
+			return err
+		}
+		if err := os.Symlink(link.entry.LinkTarget, link.outPath); err != nil {
+			return err
+		}
+	}
+
+	// deepest first so setting a child's mtime doesn't bump its parent afterwards
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i].outPath, string(filepath.Separator)) > strings.Count(dirs[j].outPath, string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		if dir.entry.Mode.Perm() != 0 {
+			if err := os.Chmod(dir.outPath, dir.entry.Mode.Perm()); err != nil {
+				return err
+			}
+		}
+		if err := os.Chtimes(dir.outPath, dir.entry.ModTime, dir.entry.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeArchive creates a new archive at dest containing a manifest followed by each source, encrypting it if
+// encryption is enabled. Returns the sha256 checksum and size of the written file. The partial archive is removed on failure.
+func writeArchive(archiver Archiver, sources []BackupSource, dest string, progress Progress) (string, int64, error) {
+	progress = orNop(progress)
+	for _, source := range sources {
+		if !Exists(source.Path) {
+			return "", 0, errors.New("backup source " + source.Name + " does not exist")
+		}
+	}
+
+	// count what's about to be archived so progress has something to go by
+	manifest := BackupManifest{Version: 1, Created: time.Now(), Sources: sources}
+	for _, source := range sources {
+		bytes, files, err := sourceTotals(source)
+		if err != nil {
+			return "", 0, err
+		}
+		manifest.Bytes += bytes
+		manifest.Files += files
+	}
+	progress.SetTotal(manifest.Bytes, manifest.Files)
+
+	// the uncompressed size is a safe upper bound on how much room the archive needs
+	if err := CheckDiskSpace(dest, manifest.Bytes); err != nil {
+		return "", 0, err
+	}
+
+	outFile, err := os.Create(dest)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// file <- checksum <- (encryption) <- archive
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	var out io.Writer = io.MultiWriter(outFile, hasher, counter)
+	var encrypter io.WriteCloser
+	if EncryptionEnabled() {
+		if encrypter, err = newEncryptWriter(out); err == nil {
+			out = encrypter
+		}
+	}
+
+	var aw ArchiveWriter
+	if err == nil {
+		aw, err = archiver.NewWriter(out)
+	}
+	if err == nil {
+		err = archiveSources(aw, manifest, progress)
+		if closeErr := aw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if encrypter != nil {
+		if closeErr := encrypter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(dest)
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), counter.n, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
@@ -2,14 +2,25 @@ package files
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Data-Corruption/blog"
 	"gorm.io/gorm"
 )
 
+var ErrBackupNotFound = errors.New("backup not found")
+
+const (
+	BackupKindSave    = "save"    // the game save, or configured backup sources
+	BackupKindInstall = "install" // the game server's install directory
+)
+
 var (
 	BackupsPath string
 	SaveDirPath string
@@ -31,29 +42,57 @@ func InitBackupPaths() {
 	} else {
 		panic("game save path does not exist")
 	}
+
+	// make sure the backup sources are usable
+	if err := validateBackupSources(GetBackupSources()); err != nil {
+		panic(err)
+	}
+
+	// make sure the configured archive format is supported
+	if _, err := GetArchiver(Config.BackupFormat); err != nil {
+		panic(err)
+	}
+
+	// backups from before there were kinds are all saves
+	if err := DB.Model(&Backup{}).Where("kind = '' OR kind IS NULL").Update("kind", BackupKindSave).Error; err != nil {
+		panic(err)
+	}
+
+	// load the backup encryption key if there is one
+	if err := initEncryption(); err != nil {
+		panic(err)
+	}
 }
 
-// GetBackupFilePath gets the backup from the database using its ID and returns its file path.
-func GetBackupFilePath(ID string) (string, error) {
+// GetBackup gets the backup from the database using its ID, returns ErrBackupNotFound if there isn't one.
+func GetBackup(ID string) (Backup, error) {
 	var backup Backup
 
 	// Convert the ID string to a uint.
 	backupId, err := strconv.ParseUint(ID, 10, 32)
 	if err != nil {
-		return "", err
+		return backup, err
 	}
 
 	// Query the database for the backup with the given ID.
 	result := DB.Where("id = ?", backupId).First(&backup)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return "", nil
+			return backup, ErrBackupNotFound
 		}
-		return "", result.Error
+		return backup, result.Error
 	}
 
-	// Return the path of the found backup.
-	return backup.Path, nil
+	return backup, nil
+}
+
+// GetBackupFilePath gets the backup from the database using its ID and returns its file path.
+func GetBackupFilePath(ID string) (string, error) {
+	backup, err := GetBackup(ID)
+	if err == ErrBackupNotFound {
+		return "", nil
+	}
+	return backup.Path, err
 }
 
 // helper function that reverses a slice of backups
@@ -68,7 +107,7 @@ func GetAllBackups() ([]Backup, error) {
 	var backups []Backup
 
 	// Query the database for all backups.
-	result := DB.Find(&backups)
+	result := DB.Preload("Replicas").Find(&backups)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -79,57 +118,212 @@ func GetAllBackups() ([]Backup, error) {
 	return backups, nil
 }
 
-// assumes server is stopped
-func CreateBackup(comment string) error {
-	if !Exists(SavePath) {
-		return errors.New("game save location does not exist")
-	}
+// CreateBackup backs up the save (or configured backup sources). Assumes server is stopped.
+func CreateBackup(comment string, progress Progress) error {
+	return createBackup(BackupKindSave, comment, GetBackupSources(), progress)
+}
 
-	// create filename for the backup zip file using the current date and time
-	outName := time.Now().Format("2006-01-02_15-04-05") + ".zip"
-	outPath := filepath.Join(BackupsPath, outName)
+// createBackup archives the sources to a new backup of the given kind, then replicates it and prunes old ones.
+func createBackup(kind, comment string, sources []BackupSource, progress Progress) error {
+	archiver, err := GetArchiver(Config.BackupFormat)
+	if err != nil {
+		return err
+	}
 
-	// zip the game save to the backups directory
-	if saveIsDir {
-		if err := ZipDir(SavePath, outPath); err != nil {
-			return err
+	// create filename for the backup archive using the current date and time, numbered if there's one already
+	baseName := time.Now().Format("2006-01-02_15-04-05")
+	if kind != BackupKindSave {
+		baseName = kind + "_" + baseName
+	}
+	var outName, outPath string
+	for i := 1; outPath == "" || Exists(outPath); i++ {
+		outName = baseName
+		if i > 1 {
+			outName += "_" + strconv.Itoa(i)
 		}
-	} else {
-		if err := ZipFile(SavePath, outPath); err != nil {
-			return err
+		outName += "." + archiver.Extension()
+		if EncryptionEnabled() {
+			outName += encryptedExtension
 		}
+		outPath = filepath.Join(BackupsPath, outName)
+	}
+
+	// archive the backup sources to the backups directory
+	checksum, size, err := writeArchive(archiver, sources, outPath, progress)
+	if err != nil {
+		return err
 	}
 
 	// Create a new Backup instance.
 	backup := Backup{
-		Path:    outPath,
-		Name:    outName,
-		Comment: comment,
+		Path:      outPath,
+		Name:      outName,
+		Comment:   comment,
+		Kind:      kind,
+		Format:    archiver.Extension(),
+		Encrypted: EncryptionEnabled(),
+		Checksum:  checksum,
+		Size:      size,
 	}
 
 	// Add the new record to the database.
-	result := DB.Create(&backup)
+	if result := DB.Create(&backup); result.Error != nil {
+		return result.Error
+	}
+
+	// the new backup is safe, old ones can go
+	if err := pruneBackups(kind, orNop(progress)); err != nil {
+		blog.Error(fmt.Sprintf("Failed to prune old %s backups: %s", kind, err.Error()))
+	}
 
-	return result.Error
+	// copy it offsite in the background
+	return queueReplication(backup)
 }
 
 // assumes server is stopped
-func RestoreBackup(backupPath string) error {
-	// Check if the backup file exists.
-	if !Exists(backupPath) {
-		return errors.New("backup file does not exist")
+func RestoreBackup(backup Backup, progress Progress) error {
+	progress = orNop(progress)
+
+	// Check if the backup file exists, falling back to a remote copy if it doesn't.
+	if !Exists(backup.Path) {
+		target, err := firstRemoteCopy(backup)
+		if err != nil {
+			return errors.New("backup file does not exist and has no remote copy")
+		}
+		return RestoreBackupFromRemote(backup, target, progress)
+	}
+
+	// make sure the backup can be read before deleting anything
+	if backup.Encrypted && !EncryptionEnabled() {
+		return ErrNoEncryptionKey
 	}
 
-	// clean the game save
-	err := os.RemoveAll(SavePath)
+	manifest, err := readManifest(backup)
 	if err != nil {
 		return err
 	}
+	if manifest != nil {
+		progress.SetTotal(manifest.Bytes, manifest.Files)
+	}
+	if err := checkRestoreDiskSpace(backup, manifest); err != nil {
+		return err
+	}
+
+	// clean the live copy of everything in the backup
+	progress.Logf("Removing live files")
+	if manifest == nil {
+		if err := os.RemoveAll(SavePath); err != nil {
+			return err
+		}
+	} else {
+		for _, source := range manifest.Sources {
+			if err := clearSource(source, "."); err != nil {
+				return err
+			}
+		}
+	}
+
+	// extract each part of the backup back where it came from
+	progress.Logf("Extracting %s", backup.Name)
+	return extractBackup(backup, resolverFor(manifest), nil, progress)
+}
+
+// checkRestoreDiskSpace makes sure every filesystem being restored to has room for its part of the backup, counting
+// the space freed by removing the live copy first. Backups without a manifest don't record their uncompressed size,
+// so their archive size is used as a rough guess.
+func checkRestoreDiskSpace(backup Backup, manifest *BackupManifest) error {
+	if manifest == nil {
+		source := BackupSource{Name: filepath.Base(SavePath), Path: SavePath}
+		return checkSourcesDiskSpace(SaveDirPath, []BackupSource{source}, backup.Size)
+	}
 
-	// unzip the backup file to the game save directory
-	if err := UnZipDir(backupPath, SaveDirPath); err != nil {
+	// the manifest only has the total, so add up each source from the archive
+	sourceBytes := map[string]int64{}
+	err := walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if !entry.IsDir && entry.LinkTarget == "" {
+			sourceName, _, _ := strings.Cut(entry.Path, "/")
+			sourceBytes[sourceName] += entry.Size
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
+	// sources on the same filesystem share its free space
+	type diskGroup struct {
+		path    string
+		sources []BackupSource
+		needed  int64
+	}
+	var groups []*diskGroup
+	byDevice := map[uint64]*diskGroup{}
+	for _, source := range manifest.Sources {
+		diskPath := existingParent(source.Path)
+		device, err := deviceOf(diskPath)
+		if err != nil {
+			return err
+		}
+		group := byDevice[device]
+		if group == nil {
+			group = &diskGroup{path: diskPath}
+			byDevice[device] = group
+			groups = append(groups, group)
+		}
+		group.sources = append(group.sources, source)
+		group.needed += sourceBytes[source.Name]
+	}
+	for _, group := range groups {
+		if err := checkSourcesDiskSpace(group.path, group.sources, group.needed); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// checkSourcesDiskSpace checks there's room on the filesystem diskPath is on to restore needed bytes over the sources.
+func checkSourcesDiskSpace(diskPath string, sources []BackupSource, needed int64) error {
+	for _, source := range sources {
+		if !Exists(source.Path) {
+			continue
+		}
+		liveBytes, _, err := sourceTotals(source)
+		if err != nil {
+			return err
+		}
+		needed -= liveBytes
+	}
+	return CheckDiskSpace(diskPath, needed)
+}
+
+// VerifyBackup checks the backup file against its stored checksum, then reads through the entire archive,
+// which also authenticates every chunk of encrypted backups.
+func VerifyBackup(backup Backup) error {
+	if !Exists(backup.Path) {
+		return errors.New("backup file does not exist")
+	}
+
+	// backups made before checksums were stored skip straight to reading the archive
+	if backup.Checksum != "" {
+		file, err := os.Open(backup.Path)
+		if err != nil {
+			return err
+		}
+		digest, err := hashReader(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if digest.Hash != backup.Checksum || digest.Size != backup.Size {
+			return errors.New("backup checksum mismatch, the file has been modified or corrupted")
+		}
+	}
+
+	return walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if r != nil {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}
+		return nil
+	})
+}
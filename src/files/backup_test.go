@@ -0,0 +1,86 @@
+package files
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSourcesBackup writes a zip backup of the sources, with their manifest first like createBackup does.
+func writeSourcesBackup(t *testing.T, path string, sources []BackupSource) Backup {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	aw, err := zipArchiver{}.NewWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archiveSources(aw, BackupManifest{Version: 1, Created: time.Now(), Sources: sources}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return Backup{Path: path, Name: "sources", Kind: BackupKindSave}
+}
+
+// A source on a second filesystem has to be checked against that filesystem's free space, not the first source's.
+func TestCheckRestoreDiskSpaceSecondFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	otherDir, err := os.MkdirTemp("/dev/shm", "tsm-test")
+	if err != nil {
+		t.Skip("no /dev/shm to use as a second filesystem")
+	}
+	t.Cleanup(func() { os.RemoveAll(otherDir) })
+	dirDevice, _ := deviceOf(dir)
+	otherDevice, _ := deviceOf(otherDir)
+	if dirDevice == otherDevice {
+		t.Skip("the temp dir is already on /dev/shm")
+	}
+
+	sources := []BackupSource{
+		{Name: "world", Path: filepath.Join(dir, "world")},
+		{Name: "other", Path: filepath.Join(otherDir, "other")},
+	}
+	writeFiles(t, dir, map[string]string{"world/level.dat": "level"})
+	writeFiles(t, otherDir, map[string]string{"other/big.dat": string(bytes.Repeat([]byte{'x'}, 2<<20))})
+	backup := writeSourcesBackup(t, filepath.Join(dir, "backup.zip"), sources)
+	manifest, err := readManifest(backup)
+	if err != nil || manifest == nil {
+		t.Fatalf("couldn't read the manifest: %v", err)
+	}
+
+	config := Config
+	t.Cleanup(func() { Config = config })
+	Config.MinFreeDiskMB = 0
+	if err := checkRestoreDiskSpace(backup, manifest); err != nil {
+		t.Fatalf("restoring over the live copy should fit: %s", err)
+	}
+
+	// with the live copy gone the second filesystem needs 2 MiB, keep all but less than 1 MiB of it free
+	if err := os.RemoveAll(sources[1].Path); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := GetDiskUsage("", otherDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := GetDiskUsage("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Free < usage.Free+(4<<20) {
+		t.Skip("the first filesystem doesn't have more room than the second")
+	}
+	Config.MinFreeDiskMB = int(usage.Free >> 20)
+	err = checkRestoreDiskSpace(backup, manifest)
+	if err == nil || !strings.Contains(err.Error(), otherDir) {
+		t.Fatalf("expected the second filesystem to be short on space, got %v", err)
+	}
+}
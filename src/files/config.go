@@ -12,6 +12,7 @@ var (
 )
 
 type ConfigInterface struct {
+	Preset           string `json:"preset"` // optional, fills in anything left empty below for a known game, see files/presets
 	GameExePath      string `json:"game_exe_path"`
 	GameSavePath     string `json:"game_save_path"`
 	UpdateCommand    string `json:"update_command"`
@@ -25,14 +26,179 @@ type ConfigInterface struct {
 	BanDurationHours int    `json:"ban_dur_hours"`
 	SessionDurMins   int    `json:"session_dur_mins"`
 	LogLevel         string `json:"log_level"`
+
+	// Game process
+	GameArgs         []string `json:"game_args"`          // passed to the game exe
+	StopCommand      string   `json:"stop_command"`       // optional, written to the server's console to stop it gracefully
+	StopSignal       string   `json:"stop_signal"`        // "SIGTERM" (default) or "SIGINT", sent if there's no stop command or it doesn't work
+	StopTimeoutSecs  int      `json:"stop_timeout_secs"`  // how long to wait after the stop command before sending the signal
+	PlayerJoinRegex  string   `json:"player_join_regex"`  // optional, a console line for a player joining, with a (?P<name>) and/or (?P<id>) group
+	PlayerLeaveRegex string   `json:"player_leave_regex"` // optional, same for leaving
+
+	// Console, commands go over RCON when rcon_address is set and are typed into the server's stdin otherwise
+	RconAddress            string   `json:"rcon_address"` // optional "host:port"
+	RconPassword           string   `json:"rcon_password"`
+	RconPlayerCountCommand string   `json:"rcon_player_count_command"` // optional, e.g. "list", used when there's no player_count_command
+	RconPlayerCountRegex   string   `json:"rcon_player_count_regex"`   // finds the count in its response, with a (?P<count>) group
+	RconHotBackupBefore    []string `json:"rcon_hot_backup_before"`    // optional, run before save backups instead of stopping the server, e.g. save-off
+	RconHotBackupAfter     []string `json:"rcon_hot_backup_after"`     // run after hot backups, even if they fail, e.g. save-on
+	ConsoleWarnCommand     string   `json:"console_warn_command"`      // optional, used when there's no warn_command, {message} is replaced
+
+	// Server query, polls the game's Steam query port for players, map and version
+	QueryAddress      string `json:"query_address"`       // optional "host:port" of the query port, often the game port + 1
+	QueryIntervalSecs int    `json:"query_interval_secs"` // how often to poll, 60 by default
+	PlayerHistoryDays int    `json:"player_history_days"` // how long player counts are kept, 30 by default
+
+	// Backups
+	BackupFormat               string         `json:"backup_format"`                // "zip", "tar.gz" or "tar.zst"
+	BackupEncryptionKeyPath    string         `json:"backup_encryption_key_path"`   // optional, takes priority over the passphrase
+	BackupEncryptionPassphrase string         `json:"backup_encryption_passphrase"` // optional
+	BackupSources              []BackupSource `json:"backup_sources"`               // optional, defaults to just the game save
+	MinFreeDiskMB              int            `json:"min_free_disk_mb"`             // backups, restores and updates refuse to leave less than this free
+	BackupKeepCount            int            `json:"backup_keep_count"`            // older save backups are deleted past this many, 0 keeps them all
+	InstallBackupExclude       []string       `json:"install_backup_exclude"`       // globs relative to the install dir left out of install backups and snapshots
+	InstallBackupKeepCount     int            `json:"install_backup_keep_count"`    // older install backups are deleted past this many, 0 keeps them all
+
+	// Replication
+	ReplicationTargets     []ReplicationTarget `json:"replication_targets"`
+	ReplicationMaxAttempts int                 `json:"replication_max_attempts"`
+
+	// Updates
+	UpdateCommands    [][]string `json:"update_commands"`     // optional, argv of each step, run instead of update_command
+	UpdateTimeoutMins int        `json:"update_timeout_mins"` // how long the update can run before it's killed
+	UpdateProvider    string     `json:"update_provider"`     // "command" (runs update_command) or "steamcmd"
+	SteamCMDPath      string     `json:"steamcmd_path"`       // defaults to steamcmd on the PATH
+	SteamAppID        string     `json:"steam_app_id"`        // the dedicated server's app id, not the game's
+	SteamInstallDir   string     `json:"steam_install_dir"`   // where the server is installed, passed to +force_install_dir
+	SteamBranch       string     `json:"steam_branch"`        // optional beta branch
+	SteamLogin        string     `json:"steam_login"`         // defaults to anonymous
+
+	// Update health checks, a server that isn't healthy after an update is rolled back
+	InstallDir             string   `json:"install_dir"`               // defaults to the game exe's directory
+	UpdateHealthWindowSecs int      `json:"update_health_window_secs"` // how long the server has to stay up after an update, 0 disables rollback
+	UpdateReadyRegex       string   `json:"update_ready_regex"`        // optional, a line the server prints once it's up
+	UpdateHealthCheck      []string `json:"update_health_check"`       // optional argv of a command that exits 0 once the server is healthy
+	NotifyWebhookURL       string   `json:"notify_webhook_url"`        // optional, gets a JSON POST when an update is rolled back
+
+	// Scheduled updates
+	UpdateCheckIntervalMins   int                 `json:"update_check_interval_mins"`    // how often to check for updates, 0 disables scheduled updates
+	UpdateCheckCommand        []string            `json:"update_check_command"`          // optional argv, exits 0 if there's an update and 1 if not, instead of asking the provider
+	MaintenanceWindows        []MaintenanceWindow `json:"maintenance_windows"`           // when found updates are applied, any time if empty
+	UpdateWarningMins         []int               `json:"update_warning_mins"`           // warn players this many minutes before a scheduled update
+	UpdateSkipIfPlayersOnline bool                `json:"update_skip_if_players_online"` // wait for an empty server within the window
+
+	// Mods
+	ModsDir            string `json:"mods_dir"`              // where the game loads mods from, mods are disabled if empty
+	ModsEnableMode     string `json:"mods_enable_mode"`      // "symlink" (default) or "move" for games that don't follow links
+	ModLoadOrderFile   string `json:"mod_load_order_file"`   // optional, rewritten with the enabled mods in order whenever they change
+	ModLoadOrderFormat string `json:"mod_load_order_format"` // each line of the load order file, {file} and {name} are replaced
+
+	// File manager
+	FileManagerRoot string `json:"file_manager_root"` // the dashboard's file manager can't see outside this, disabled if empty
+
+	// Maintenance mode, turned on from the dashboard while restoring, updating etc.
+	MaintenanceEnterCommands []string `json:"maintenance_enter_commands"` // run in the console when it's turned on, e.g. "whitelist on"
+	MaintenanceExitCommands  []string `json:"maintenance_exit_commands"`  // run when it's turned off
+
+	// Access lists
+	AccessLists []AccessList `json:"access_lists"` // whitelists, ban lists etc. managed from the dashboard, presets fill these in
+
+	// Players
+	PlayerCountCommand []string `json:"player_count_command"` // optional argv that prints the number of players online
+	WarnCommand        []string `json:"warn_command"`         // optional argv that messages players, {message} is replaced with the message
+
+	// What scheduled actions do when players are online
+	AutoBackupPlayerPolicy PlayerPolicy `json:"auto_backup_player_policy"` // the nightly backup
+	UpdatePlayerPolicy     PlayerPolicy `json:"update_player_policy"`      // scheduled updates, overrides update_skip_if_players_online
+}
+
+// MaintenanceWindow is a time of the week when disruptive things like updates are allowed to happen.
+type MaintenanceWindow struct {
+	Days         []string `json:"days"`          // "mon", "tue", etc., every day if empty
+	Start        string   `json:"start"`         // "15:04", local time
+	DurationMins int      `json:"duration_mins"` // how long the window stays open
+}
+
+// AccessList is a list of players the game keeps in a file, like a whitelist, ban list or admin list.
+type AccessList struct {
+	Name          string `json:"name"`           // shown on the dashboard and used in URLs, must be unique
+	Path          string `json:"path"`           // the file the game reads the list from
+	Format        string `json:"format"`         // "lines" (default), "json" (an array of names) or "json_objects" (objects with a "name")
+	AddCommand    string `json:"add_command"`    // optional, run in the console instead of editing the file while the server's up, {name} is replaced
+	RemoveCommand string `json:"remove_command"` // same for removing
+}
+
+// PlayerPolicy is what a scheduled action does when players are online.
+type PlayerPolicy struct {
+	Action       string `json:"action"`         // "warn" (default) warns them then goes ahead, "skip" or "defer" until the server's empty
+	MaxDelayMins int    `json:"max_delay_mins"` // defer: how long to wait before warning and going ahead anyway, 0 waits as long as it takes
+	WarningMins  []int  `json:"warning_mins"`   // warn players this many minutes before, update_warning_mins for updates if empty
+}
+
+// BackupSource is a file or directory included in backups.
+type BackupSource struct {
+	Name    string   `json:"name"`    // folder name inside the archive, must be unique
+	Path    string   `json:"path"`    // where it lives, and where it's restored to
+	Include []string `json:"include"` // globs relative to path, everything is included if empty
+	Exclude []string `json:"exclude"` // globs relative to path, "*.log" matches at any depth, "**" any number of dirs
+}
+
+// ReplicationTarget is an offsite location backups are copied to after they're created.
+type ReplicationTarget struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "s3", "sftp" or "dir"
+	Path string `json:"path"` // dir: mirror directory, s3: key prefix, sftp: remote directory
+	// s3 compatible storage, path style addressing is used so MinIO etc. work without DNS setup
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// sftp, either password or key path (or both). Host keys are checked against known_hosts_path, ~/.ssh/known_hosts by default
+	Host           string `json:"host"` // "host" or "host:port"
+	User           string `json:"user"`
+	Password       string `json:"password"`
+	KeyPath        string `json:"key_path"`
+	KnownHostsPath string `json:"known_hosts_path"`
 }
 
 func setDefaultConfigValues() {
-	Config = ConfigInterface{}
-	Config.TrustProxy = true
-	Config.BanDurationHours = 1
-	Config.SessionDurMins = 15
-	Config.LogLevel = "warn"
+	Config = DefaultConfig()
+}
+
+// DefaultConfig returns the config a new config.json is generated with.
+func DefaultConfig() ConfigInterface {
+	config := ConfigInterface{}
+	config.TrustProxy = true
+	config.BanDurationHours = 1
+	config.SessionDurMins = 15
+	config.LogLevel = "warn"
+	config.GameArgs = []string{}
+	config.StopTimeoutSecs = 30
+	config.BackupFormat = FormatZip
+	config.BackupSources = []BackupSource{}
+	config.MinFreeDiskMB = 1024
+	config.QueryIntervalSecs = 60
+	config.PlayerHistoryDays = 30
+	config.InstallBackupExclude = []string{}
+	config.InstallBackupKeepCount = 3
+	config.ReplicationTargets = []ReplicationTarget{}
+	config.ReplicationMaxAttempts = 5
+	config.UpdateCommands = [][]string{}
+	config.UpdateTimeoutMins = 30
+	config.UpdateProvider = "command"
+	config.UpdateHealthWindowSecs = 120
+	config.UpdateHealthCheck = []string{}
+	config.UpdateCheckCommand = []string{}
+	config.MaintenanceWindows = []MaintenanceWindow{}
+	config.UpdateWarningMins = []int{15, 5, 1}
+	config.ModsEnableMode = ModModeSymlink
+	config.ModLoadOrderFormat = "{file}"
+	config.PlayerCountCommand = []string{}
+	config.RconHotBackupBefore = []string{}
+	config.RconHotBackupAfter = []string{}
+	config.WarnCommand = []string{}
+	return config
 }
 
 // LoadConfig loads the configuration from database, or creates a new one if it doesn't exist.
@@ -59,6 +225,13 @@ func LoadConfig() {
 	if err != nil {
 		log.Fatalf("Error parsing config file: %s\n", err)
 	}
+
+	// Fill in the gaps from the preset, if there is one
+	if Config.Preset != "" {
+		if err := applyPreset(file); err != nil {
+			log.Fatalf("Error applying preset: %s\n", err)
+		}
+	}
 }
 
 // SaveConfig saves the configuration to the database.
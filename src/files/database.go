@@ -29,9 +29,92 @@ type RateLimitedIp struct {
 
 type Backup struct {
 	gorm.Model
-	Path    string
-	Name    string
-	Comment string
+	Path      string
+	Name      string
+	Comment   string
+	Kind      string // BackupKindSave or BackupKindInstall
+	Format    string // archive format, see GetArchiver. Empty for backups made before formats were configurable (zip)
+	Encrypted bool
+	Checksum  string // sha256 of the file on disk, empty for backups made before checksums were stored
+	Size      int64  // size of the file on disk
+	Replicas  []BackupReplica
+}
+
+// BackupReplica is the upload state of a backup on a single replication target.
+type BackupReplica struct {
+	gorm.Model
+	BackupID    uint   `gorm:"index"`
+	Target      string // name of the replication target in the config
+	Status      string // ReplicaPending, ReplicaUploaded or ReplicaFailed
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	UploadedAt  *time.Time
+}
+
+// Job is a long running operation (backup, restore, update) run in the background, see game.StartJob.
+type Job struct {
+	gorm.Model
+	Type          string // e.g. "backup", "restore", "update"
+	Status        string // JobQueued, JobRunning, JobSucceeded or JobFailed
+	ProgressBytes int64
+	TotalBytes    int64 // 0 if unknown
+	ProgressFiles int64
+	TotalFiles    int64 // 0 if unknown
+	Log           string
+	Output        string // output of commands run by the job, e.g. the update command
+	Error         string
+	StartedAt     *time.Time
+	FinishedAt    *time.Time
+}
+
+// ShareLink lets someone without a login download a single backup until it expires, see files/share.go.
+type ShareLink struct {
+	gorm.Model
+	BackupID     uint `gorm:"index"`
+	Exp          time.Time
+	MaxDownloads int // 0 is unlimited
+	Downloads    int
+	Revoked      bool
+}
+
+// Mod is a mod file in TSM's mod library. Enabled mods are linked (or moved) into the configured mods directory.
+type Mod struct {
+	gorm.Model
+	FileName    string `gorm:"uniqueIndex"`
+	Size        int64
+	Checksum    string // sha256 of the file
+	Enabled     bool
+	EnabledMode string // ModModeSymlink or ModModeMove while enabled, so it's disabled the same way
+	Position    int    // load order, lowest first
+}
+
+// PlayerCountSample is how many players were online at a point in time, recorded by the server query.
+type PlayerCountSample struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	Players    int
+	MaxPlayers int
+}
+
+// PlayerSession is a player's time on the server, from the join and leave lines in its output.
+type PlayerSession struct {
+	gorm.Model
+	Name         string    `gorm:"index"`
+	PlayerID     string    `gorm:"index"` // empty if the join line doesn't include one
+	JoinedAt     time.Time `gorm:"index"`
+	LeftAt       *time.Time
+	DurationSecs int64
+	EndReason    string // PlayerLeft, PlayerServerStopped or PlayerTSMStopped, empty while they're online
+}
+
+// GameVersion is an installed build of the game server, recorded whenever it changes.
+type GameVersion struct {
+	gorm.Model
+	BuildID    string
+	Previous   string // build it replaced, empty if unknown
+	Provider   string // update provider that reported it
+	RolledBack bool   // the server wasn't healthy on it and it was rolled back, so it isn't scheduled again
 }
 
 // Session represents a user session in the system.
@@ -75,6 +158,9 @@ func startCleaner() {
 				if err := RemoveExpiredEntries(&Session{}); err != nil {
 					blog.Error(err.Error())
 				}
+				if err := RemoveExpiredEntries(&ShareLink{}); err != nil {
+					blog.Error(err.Error())
+				}
 			case <-closeCleanerReq:
 				ticker.Stop()
 				closeCleanerAck <- true
@@ -96,7 +182,7 @@ func InitDatabase() {
 	}
 
 	// Migrate the schemas
-	if err = db.AutoMigrate(&RateLimitedIp{}, &Session{}, &Backup{}); err != nil {
+	if err = db.AutoMigrate(&RateLimitedIp{}, &Session{}, &Backup{}, &BackupReplica{}, &Job{}, &ShareLink{}, &GameVersion{}, &Mod{}, &PlayerCountSample{}, &PlayerSession{}); err != nil {
 		panic("failed to migrate database")
 	}
 
@@ -0,0 +1,198 @@
+package files
+
+// files/diff.go is for comparing the contents of backups with each other or with the live files.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// FileDigest is the size and sha256 hash of a single file.
+type FileDigest struct {
+	Size int64
+	Hash string
+}
+
+// DiffEntry is a single file that differs between two snapshots.
+type DiffEntry struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"` // DiffAdded, DiffRemoved or DiffModified
+	OldSize int64  `json:"oldSize"`
+	NewSize int64  `json:"newSize"`
+	OldHash string `json:"oldHash"`
+	NewHash string `json:"newHash"`
+}
+
+// BackupDiff is the result of comparing two snapshots.
+type BackupDiff struct {
+	Added    int         `json:"added"`
+	Removed  int         `json:"removed"`
+	Modified int         `json:"modified"`
+	Changes  []DiffEntry `json:"changes"`
+}
+
+func hashReader(r io.Reader) (FileDigest, error) {
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, r)
+	if err != nil {
+		return FileDigest{}, err
+	}
+	return FileDigest{Size: size, Hash: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// symlinks are compared by their target
+func linkDigest(target string) FileDigest {
+	digest, _ := hashReader(strings.NewReader("symlink:" + target))
+	digest.Size = 0
+	return digest
+}
+
+// digestBackup hashes every file in the backup's archive, keyed by entry path.
+func digestBackup(backup Backup) (map[string]FileDigest, error) {
+	digests := map[string]FileDigest{}
+	err := walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if entry.IsDir || entry.Path == manifestName {
+			return nil
+		}
+		if entry.Mode&os.ModeSymlink != 0 {
+			digests[entry.Path] = linkDigest(entry.LinkTarget)
+			return nil
+		}
+		if r == nil {
+			return nil
+		}
+		digest, err := hashReader(r)
+		if err != nil {
+			return err
+		}
+		digests[entry.Path] = digest
+		return nil
+	})
+	return digests, err
+}
+
+// liveSources returns where a backup's files live now. That's the sources recorded in its manifest, so an install
+// backup is compared with the install directory. Backups from before manifests only ever had the game save.
+func liveSources(backup Backup) ([]BackupSource, error) {
+	switch backup.Kind {
+	case BackupKindSave, BackupKindInstall:
+	default:
+		return nil, fmt.Errorf("%s backups have nothing live to compare with", backup.Kind)
+	}
+	manifest, err := readManifest(backup)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return []BackupSource{{Name: filepath.Base(Config.GameSavePath), Path: Config.GameSavePath}}, nil
+	}
+	return manifest.Sources, nil
+}
+
+// digestLiveSources hashes every file in the live sources, keyed the same way as archive entries. A source that's
+// gone counts as empty.
+func digestLiveSources(sources []BackupSource) (map[string]FileDigest, error) {
+	digests := map[string]FileDigest{}
+	for _, source := range sources {
+		if !Exists(source.Path) {
+			continue
+		}
+		err := walkSource(source, func(path, rel string, d os.DirEntry) error {
+			if d.IsDir() {
+				return nil
+			}
+			name := sourceEntryName(source, rel)
+			if d.Type()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				digests[name] = linkDigest(target)
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			digest, err := hashReader(file)
+			if err != nil {
+				return err
+			}
+			digests[name] = digest
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return digests, nil
+}
+
+// diffDigests compares two sets of digests, from being the older of the two.
+func diffDigests(from, to map[string]FileDigest) BackupDiff {
+	diff := BackupDiff{Changes: []DiffEntry{}}
+	for path, old := range from {
+		current, ok := to[path]
+		if !ok {
+			diff.Removed++
+			diff.Changes = append(diff.Changes, DiffEntry{Path: path, Status: DiffRemoved, OldSize: old.Size, OldHash: old.Hash})
+		} else if current != old {
+			diff.Modified++
+			diff.Changes = append(diff.Changes, DiffEntry{Path: path, Status: DiffModified, OldSize: old.Size, NewSize: current.Size, OldHash: old.Hash, NewHash: current.Hash})
+		}
+	}
+	for path, current := range to {
+		if _, ok := from[path]; !ok {
+			diff.Added++
+			diff.Changes = append(diff.Changes, DiffEntry{Path: path, Status: DiffAdded, NewSize: current.Size, NewHash: current.Hash})
+		}
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].Path < diff.Changes[j].Path })
+	return diff
+}
+
+// DiffBackups compares the contents of two backups.
+func DiffBackups(fromBackup, toBackup Backup) (BackupDiff, error) {
+	from, err := digestBackup(fromBackup)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	to, err := digestBackup(toBackup)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	return diffDigests(from, to), nil
+}
+
+// DiffBackupWithLive compares the contents of a backup with the live files it was made from.
+func DiffBackupWithLive(fromBackup Backup) (BackupDiff, error) {
+	sources, err := liveSources(fromBackup)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	from, err := digestBackup(fromBackup)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	to, err := digestLiveSources(sources)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	return diffDigests(from, to), nil
+}
@@ -0,0 +1,93 @@
+package files
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, contents map[string]string) {
+	t.Helper()
+	for name, content := range contents {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeLegacyBackup writes a zip like the ones made before manifests, with entries relative to the save's parent.
+func writeLegacyBackup(t *testing.T, path string, entries map[string]string) Backup {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for name, content := range entries {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return Backup{Path: path, Name: "legacy", Kind: BackupKindSave}
+}
+
+func TestDiffLegacyBackupWithLive(t *testing.T) {
+	dir := t.TempDir()
+	config := Config
+	t.Cleanup(func() { Config = config })
+	Config.GameSavePath = filepath.Join(dir, "world")
+	// sources added since the backup was made shouldn't show up as added files
+	Config.BackupSources = []BackupSource{
+		{Name: "world", Path: Config.GameSavePath},
+		{Name: "configs", Path: filepath.Join(dir, "configs")},
+	}
+	writeFiles(t, dir, map[string]string{
+		"world/level.dat":           "level",
+		"world/region/r.0.0.mca":    "changed",
+		"world/region/r.0.1.mca":    "new",
+		"configs/server.properties": "motd=hi",
+	})
+	backup := writeLegacyBackup(t, filepath.Join(dir, "legacy.zip"), map[string]string{
+		"world/level.dat":        "level",
+		"world/region/r.0.0.mca": "original",
+		"world/old.dat":          "old",
+	})
+
+	diff, err := DiffBackupWithLive(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"world/region/r.0.0.mca": DiffModified,
+		"world/region/r.0.1.mca": DiffAdded,
+		"world/old.dat":          DiffRemoved,
+	}
+	if len(diff.Changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(diff.Changes), len(want), diff.Changes)
+	}
+	for _, change := range diff.Changes {
+		if want[change.Path] != change.Status {
+			t.Errorf("%s is %s, want %q", change.Path, change.Status, want[change.Path])
+		}
+	}
+}
+
+func TestDiffWithLiveNeedsALiveCopy(t *testing.T) {
+	backup := Backup{Path: filepath.Join(t.TempDir(), "missing.zip"), Kind: "snapshot"}
+	if _, err := DiffBackupWithLive(backup); err == nil {
+		t.Fatal("expected an error for a kind of backup with no live copy")
+	}
+}
@@ -0,0 +1,113 @@
+package files
+
+// files/disk.go is for checking there's enough free disk space before filling it up.
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// DiskUsage is the usage of the filesystem a path is on.
+type DiskUsage struct {
+	Name  string // what's on it, e.g. "Backups"
+	Path  string
+	Total uint64
+	Free  uint64 // available to unprivileged users
+}
+
+func (d DiskUsage) Used() uint64 {
+	return d.Total - d.Free
+}
+
+// UsedPercent is for the dashboard.
+func (d DiskUsage) UsedPercent() int {
+	if d.Total == 0 {
+		return 0
+	}
+	return int(d.Used() * 100 / d.Total)
+}
+
+func (d DiskUsage) String() string {
+	return fmt.Sprintf("%s free of %s", FormatBytes(int64(d.Free)), FormatBytes(int64(d.Total)))
+}
+
+// FormatBytes formats a size for people, e.g. 1536 -> "1.5 KiB"
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTP"[exp])
+}
+
+// GetDiskUsage returns the usage of the filesystem path is on. path must exist.
+func GetDiskUsage(name, path string) (DiskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{
+		Name:  name,
+		Path:  path,
+		Total: stat.Blocks * uint64(stat.Bsize),
+		Free:  stat.Bavail * uint64(stat.Bsize),
+	}, nil
+}
+
+// GetDiskUsages returns the usage of the backups and save filesystems, for the dashboard.
+func GetDiskUsages() ([]DiskUsage, error) {
+	backups, err := GetDiskUsage("Backups", BackupsPath)
+	if err != nil {
+		return nil, err
+	}
+	save, err := GetDiskUsage("Save", SaveDirPath)
+	if err != nil {
+		return nil, err
+	}
+	return []DiskUsage{backups, save}, nil
+}
+
+// CheckDiskSpace returns an error if writing needed bytes to the filesystem path is on would leave less than
+// the configured minimum free. path doesn't need to exist, the closest existing parent is checked.
+func CheckDiskSpace(path string, needed int64) error {
+	path = existingParent(path)
+	usage, err := GetDiskUsage("", path)
+	if err != nil {
+		return err
+	}
+	if needed < 0 {
+		needed = 0
+	}
+	minFree := uint64(0)
+	if Config.MinFreeDiskMB > 0 {
+		minFree = uint64(Config.MinFreeDiskMB) << 20
+	}
+	if usage.Free < uint64(needed)+minFree {
+		return fmt.Errorf("not enough disk space on %s: need about %s plus %d MB kept free, only %s free",
+			path, FormatBytes(needed), Config.MinFreeDiskMB, FormatBytes(int64(usage.Free)))
+	}
+	return nil
+}
+
+// existingParent returns path, or its closest parent that exists.
+func existingParent(path string) string {
+	for !Exists(path) && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+	}
+	return path
+}
+
+// deviceOf returns the id of the filesystem path is on, paths with the same id share free space.
+func deviceOf(path string) (uint64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Dev), nil
+}
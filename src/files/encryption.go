@@ -0,0 +1,233 @@
+package files
+
+// files/encryption.go is for encrypting backups at rest.
+//
+// Encrypted backups are the archive split into 64 KiB chunks, each sealed with AES-256-GCM.
+// Layout: magic (8) | scrypt salt (16) | nonce prefix (7) | chunks...
+// Chunk nonces are prefix (7) | chunk counter (4, big endian) | last chunk flag (1), so chunks
+// can't be reordered, dropped or truncated from the end without failing authentication.
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptionMagic     = "TSMENC01"
+	encryptionSaltSize  = 16
+	encryptionPrefixLen = 7
+	encryptionChunkSize = 64 * 1024
+	encryptedExtension  = ".enc"
+)
+
+var (
+	ErrNoEncryptionKey    = errors.New("backup is encrypted but no encryption key is configured")
+	ErrDecryptionFailed   = errors.New("failed to decrypt backup, wrong key or corrupted file")
+	ErrNotEncryptedBackup = errors.New("file is not an encrypted backup")
+	encryptionSecret      []byte // key file contents or passphrase, nil if encryption is disabled
+)
+
+// initEncryption loads the encryption secret from the config, key file taking priority over passphrase.
+func initEncryption() error {
+	encryptionSecret = nil
+	if Config.BackupEncryptionKeyPath != "" {
+		secret, err := os.ReadFile(Config.BackupEncryptionKeyPath)
+		if err != nil {
+			return err
+		}
+		if len(strings.TrimSpace(string(secret))) == 0 {
+			return errors.New("backup encryption key file is empty")
+		}
+		encryptionSecret = secret
+	} else if Config.BackupEncryptionPassphrase != "" {
+		encryptionSecret = []byte(Config.BackupEncryptionPassphrase)
+	}
+	return nil
+}
+
+// EncryptionEnabled returns true if new backups will be encrypted.
+func EncryptionEnabled() bool {
+	return encryptionSecret != nil
+}
+
+func newChunkCipher(salt []byte) (cipher.AEAD, error) {
+	if encryptionSecret == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	key, err := scrypt.Key(encryptionSecret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixLen:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// ==== Writer ================================================================
+
+type encryptWriter struct {
+	dest    io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// newEncryptWriter returns a writer that encrypts everything written to it into dest. Close must be called to
+// write the final chunk, it does not close dest.
+func newEncryptWriter(dest io.Writer) (io.WriteCloser, error) {
+	header := make([]byte, len(encryptionMagic)+encryptionSaltSize+encryptionPrefixLen)
+	copy(header, encryptionMagic)
+	if _, err := rand.Read(header[len(encryptionMagic):]); err != nil {
+		return nil, err
+	}
+	salt := header[len(encryptionMagic) : len(encryptionMagic)+encryptionSaltSize]
+	aead, err := newChunkCipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dest.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		dest:   dest,
+		aead:   aead,
+		prefix: header[len(encryptionMagic)+encryptionSaltSize:],
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (ew *encryptWriter) sealChunk(last bool) error {
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.prefix, ew.counter, last), ew.buf, nil)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	_, err := ew.dest.Write(sealed)
+	return err
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full buffer is only sealed once more data arrives, so the last chunk is never empty unless the input is
+		if len(ew.buf) == encryptionChunkSize {
+			if err := ew.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(ew.buf[len(ew.buf):encryptionChunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.sealChunk(true)
+}
+
+// ==== Reader ================================================================
+
+type decryptReader struct {
+	source  *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte // decrypted data not yet read
+	sealed  []byte
+	done    bool
+}
+
+// newDecryptReader returns a reader that decrypts source, a stream written by newEncryptWriter.
+func newDecryptReader(source io.Reader) (io.Reader, error) {
+	header := make([]byte, len(encryptionMagic)+encryptionSaltSize+encryptionPrefixLen)
+	if _, err := io.ReadFull(source, header); err != nil {
+		return nil, ErrNotEncryptedBackup
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, ErrNotEncryptedBackup
+	}
+	aead, err := newChunkCipher(header[len(encryptionMagic) : len(encryptionMagic)+encryptionSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		source: bufio.NewReaderSize(source, encryptionChunkSize+aead.Overhead()+1),
+		aead:   aead,
+		prefix: header[len(encryptionMagic)+encryptionSaltSize:],
+		sealed: make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.chunk) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(dr.source, dr.sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return 0, ErrDecryptionFailed // truncated before the last chunk
+			}
+			return 0, err
+		}
+		// it's the last chunk if nothing follows it
+		_, peekErr := dr.source.Peek(1)
+		last := peekErr == io.EOF
+		chunk, openErr := dr.aead.Open(dr.sealed[:0], chunkNonce(dr.prefix, dr.counter, last), dr.sealed[:n], nil)
+		if openErr != nil {
+			return 0, ErrDecryptionFailed
+		}
+		dr.counter++
+		dr.chunk = chunk
+		dr.done = last
+	}
+	n := copy(p, dr.chunk)
+	dr.chunk = dr.chunk[n:]
+	return n, nil
+}
+
+// ==== Helpers ===============================================================
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openBackup opens the backup's archive for reading, decrypting it if needed.
+func openBackup(backup Backup) (io.ReadCloser, error) {
+	file, err := os.Open(backup.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !backup.Encrypted {
+		return file, nil
+	}
+	reader, err := newDecryptReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return readCloser{Reader: reader, Closer: file}, nil
+}
@@ -0,0 +1,317 @@
+package files
+
+// files/fileManager.go is for the dashboard's file manager, which can list, edit, upload, rename and delete files
+// under file_manager_root. Every path is checked after resolving symlinks, so nothing outside the root (or TSM's
+// own directory, if it's inside the root) can be reached.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxEditableSize is the biggest file the editor will open.
+const maxEditableSize = 1024 * 1024
+
+var (
+	ErrFileManagerDisabled = errors.New("file_manager_root isn't set")
+	ErrOutsideRoot         = errors.New("path is outside the file manager root")
+	ErrManagedNotFound     = errors.New("file not found")
+	ErrManagedExists       = errors.New("a file with that name already exists")
+	ErrNotEditable         = errors.New("file is too big or isn't text, download it instead")
+)
+
+// ManagedFile is a file or directory in the file manager.
+type ManagedFile struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // relative to the root, slash separated
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// managedRoot returns the file manager root with symlinks resolved.
+func managedRoot() (string, error) {
+	if Config.FileManagerRoot == "" {
+		return "", ErrFileManagerDisabled
+	}
+	root, err := filepath.Abs(Config.FileManagerRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// cleanManagedPath normalizes a path from the client, e.g. "/cfg/../server.properties" -> "server.properties".
+// The root itself is "".
+func cleanManagedPath(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", ErrOutsideRoot
+	}
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/"), nil
+}
+
+// tsmDir returns TSM's own directory with symlinks resolved.
+func tsmDir() (string, error) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(workDir)
+}
+
+// checkInsideRoot makes sure resolved (a path with no symlinks left) is inside the root, and isn't in TSM's own
+// directory when that's inside the root too.
+func checkInsideRoot(root, resolved string) error {
+	if _, ok := relativeInside(root, resolved); !ok {
+		return ErrOutsideRoot
+	}
+	workDir, err := tsmDir()
+	if err != nil {
+		return err
+	}
+	if rel, ok := relativeInside(root, workDir); ok {
+		if rel == "." {
+			return errors.New("file manager root is TSM's own directory")
+		}
+		if _, ok := relativeInside(workDir, resolved); ok {
+			return ErrOutsideRoot
+		}
+	}
+	return nil
+}
+
+// checkNotAboveTSM stops TSM's own directory being moved or deleted along with a directory it's in.
+func checkNotAboveTSM(resolved string) error {
+	workDir, err := tsmDir()
+	if err != nil {
+		return err
+	}
+	if _, ok := relativeInside(resolved, workDir); ok {
+		return ErrOutsideRoot
+	}
+	return nil
+}
+
+// resolveManagedPath turns a client path into a real path inside the root. The parent directory always has its
+// symlinks resolved. The last element only does if followLast is set, so renaming or deleting a symlink acts on the
+// link itself. The file doesn't have to exist.
+func resolveManagedPath(name string, followLast bool) (string, error) {
+	root, err := managedRoot()
+	if err != nil {
+		return "", err
+	}
+	rel, err := cleanManagedPath(name)
+	if err != nil {
+		return "", err
+	}
+	if rel == "" {
+		return root, checkInsideRoot(root, root)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Join(root, filepath.Dir(filepath.FromSlash(rel))))
+	if os.IsNotExist(err) {
+		return "", ErrManagedNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Join(parent, path.Base(rel))
+	if followLast {
+		target, err := filepath.EvalSymlinks(resolved)
+		if err == nil {
+			resolved = target
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if err := checkInsideRoot(root, resolved); err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// ListManagedDir lists a directory in the file manager, directories first.
+func ListManagedDir(name string) ([]ManagedFile, error) {
+	dirPath, err := resolveManagedPath(name, true)
+	if err != nil {
+		return nil, err
+	}
+	rel, _ := cleanManagedPath(name)
+	dirEntries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return nil, ErrManagedNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []ManagedFile{}
+	for _, entry := range dirEntries {
+		entryPath := path.Join(rel, entry.Name())
+		// hide anything that leads outside, e.g. a symlink to /etc or TSM's own folder
+		if _, err := resolveManagedPath(entryPath, true); err != nil {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			continue // broken link
+		}
+		list = append(list, ManagedFile{
+			Name:    entry.Name(),
+			Path:    entryPath,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].IsDir && !list[j].IsDir })
+	return list, nil
+}
+
+// GetManagedFilePath returns the real path of a file in the file manager, for downloading.
+func GetManagedFilePath(name string) (string, error) {
+	filePath, err := resolveManagedPath(name, true)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return "", ErrManagedNotFound
+	}
+	return filePath, err
+}
+
+// ReadManagedFile returns a text file's contents for the editor.
+func ReadManagedFile(name string) ([]byte, error) {
+	filePath, err := GetManagedFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxEditableSize {
+		return nil, ErrNotEditable
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data, 0) != -1 || !utf8.Valid(data) {
+		return nil, ErrNotEditable
+	}
+	return data, nil
+}
+
+// WriteManagedFile replaces a file's contents, or creates it. It's written to a temp file first so a failed write
+// can't leave it half written, and existing files keep their permissions.
+func WriteManagedFile(name string, r io.Reader) error {
+	if isManagedRoot(name) {
+		return ErrOutsideRoot
+	}
+	filePath, err := resolveManagedPath(name, true)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		if info.IsDir() {
+			return ErrManagedExists
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := CheckDiskSpace(filepath.Dir(filePath), 0); err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), ".tsm-upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tempFile, r)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return nil
+}
+
+// UploadManagedFile writes an uploaded file into a directory in the file manager, replacing any file with that name.
+func UploadManagedFile(dir, fileName string, r io.Reader) (string, error) {
+	fileName = path.Base(strings.ReplaceAll(fileName, `\`, "/"))
+	if fileName == "." || fileName == ".." || fileName == "/" {
+		return "", errors.New("invalid file name")
+	}
+	rel, err := cleanManagedPath(dir)
+	if err != nil {
+		return "", err
+	}
+	rel = path.Join(rel, fileName)
+	return rel, WriteManagedFile(rel, r)
+}
+
+// RenameManagedFile moves a file or directory within the file manager. It won't replace an existing file.
+func RenameManagedFile(from, to string) error {
+	if isManagedRoot(from) || isManagedRoot(to) {
+		return ErrOutsideRoot
+	}
+	fromPath, err := resolveManagedPath(from, false)
+	if err != nil {
+		return err
+	}
+	toPath, err := resolveManagedPath(to, false)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(fromPath); os.IsNotExist(err) {
+		return ErrManagedNotFound
+	}
+	if err := checkNotAboveTSM(fromPath); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(toPath); err == nil {
+		return ErrManagedExists
+	}
+	return os.Rename(fromPath, toPath)
+}
+
+// DeleteManagedFile deletes a file, or a directory and everything in it.
+func DeleteManagedFile(name string) error {
+	if isManagedRoot(name) {
+		return ErrOutsideRoot
+	}
+	filePath, err := resolveManagedPath(name, false)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+		return ErrManagedNotFound
+	}
+	if err := checkNotAboveTSM(filePath); err != nil {
+		return err
+	}
+	return os.RemoveAll(filePath)
+}
+
+func isManagedRoot(name string) bool {
+	rel, err := cleanManagedPath(name)
+	return err == nil && rel == ""
+}
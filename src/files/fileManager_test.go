@@ -0,0 +1,270 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupFileManager lays out a file manager root next to a directory outside it, with TSM running from root/game/tsm:
+//
+//	base/outside/secret.txt
+//	base/root/notes.txt
+//	base/root/cfg/server.properties
+//	base/root/game/tsm/tsm.db
+//	base/root/escape -> base/outside
+//	base/root/secret-link -> base/outside/secret.txt
+//	base/root/cfg-link -> base/root/cfg
+func setupFileManager(t *testing.T) (base, root string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.Join(base, "root")
+	writeFiles(t, base, map[string]string{
+		"outside/secret.txt":         "secret",
+		"root/notes.txt":             "notes",
+		"root/cfg/server.properties": "motd=hi",
+		"root/game/tsm/tsm.db":       "db",
+	})
+	links := map[string]string{
+		"escape":      filepath.Join(base, "outside"),
+		"secret-link": filepath.Join(base, "outside", "secret.txt"),
+		"cfg-link":    filepath.Join(root, "cfg"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(root, "game", "tsm")); err != nil {
+		t.Fatal(err)
+	}
+	config := Config
+	t.Cleanup(func() {
+		Config = config
+		os.Chdir(wd)
+	})
+	Config.FileManagerRoot = root
+	Config.MinFreeDiskMB = 0
+	return base, root
+}
+
+func TestResolveManagedPath(t *testing.T) {
+	_, root := setupFileManager(t)
+	tests := []struct {
+		name       string
+		path       string
+		followLast bool
+		want       string // relative to the root
+		err        error
+	}{
+		{name: "root", path: "", want: "."},
+		{name: "file", path: "cfg/server.properties", want: "cfg/server.properties"},
+		{name: "missing file", path: "cfg/new.txt", want: "cfg/new.txt"},
+		{name: "missing parent", path: "nope/new.txt", err: ErrManagedNotFound},
+		{name: "backslashes", path: `cfg\server.properties`, want: "cfg/server.properties"},
+		{name: "dot dot stays in the root", path: "../outside/secret.txt", err: ErrManagedNotFound},
+		{name: "dot dot back into the root", path: "cfg/../../root/notes.txt", err: ErrManagedNotFound},
+		{name: "dot dot to the root", path: "../..", want: "."},
+		{name: "dot dot inside the root", path: "cfg/../notes.txt", want: "notes.txt"},
+		{name: "backslash dot dot", path: `..\..\outside\secret.txt`, err: ErrManagedNotFound},
+		{name: "absolute path is relative to the root", path: "/cfg/server.properties", want: "cfg/server.properties"},
+		{name: "absolute path outside", path: "/etc/passwd", err: ErrManagedNotFound},
+		{name: "nul byte", path: "notes.txt\x00.png", err: ErrOutsideRoot},
+		{name: "through a symlink outside", path: "escape/secret.txt", err: ErrOutsideRoot},
+		{name: "symlink outside, followed", path: "escape", followLast: true, err: ErrOutsideRoot},
+		{name: "symlink outside, not followed", path: "escape", want: "escape"},
+		{name: "file symlink outside, followed", path: "secret-link", followLast: true, err: ErrOutsideRoot},
+		{name: "symlink inside, followed", path: "cfg-link", followLast: true, want: "cfg"},
+		{name: "through a symlink inside", path: "cfg-link/server.properties", want: "cfg/server.properties"},
+		{name: "TSM's directory", path: "game/tsm", err: ErrOutsideRoot},
+		{name: "inside TSM's directory", path: "game/tsm/tsm.db", err: ErrOutsideRoot},
+		{name: "above TSM's directory", path: "game", want: "game"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved, err := resolveManagedPath(test.path, test.followLast)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %q (%v), want %v", resolved, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(test.want)); resolved != want {
+				t.Fatalf("got %s, want %s", resolved, want)
+			}
+		})
+	}
+}
+
+func TestCheckInsideRoot(t *testing.T) {
+	base, root := setupFileManager(t)
+	tsm := filepath.Join(root, "game", "tsm")
+	tests := []struct {
+		name     string
+		root     string
+		resolved string
+		ok       bool
+	}{
+		{name: "root", root: root, resolved: root, ok: true},
+		{name: "inside", root: root, resolved: filepath.Join(root, "cfg", "server.properties"), ok: true},
+		{name: "parent", root: root, resolved: base},
+		{name: "sibling", root: root, resolved: filepath.Join(base, "outside", "secret.txt")},
+		{name: "shares a prefix", root: root, resolved: root + "2"},
+		{name: "filesystem root", root: root, resolved: "/"},
+		{name: "TSM's directory", root: root, resolved: tsm},
+		{name: "inside TSM's directory", root: root, resolved: filepath.Join(tsm, "tsm.db")},
+		{name: "root is TSM's directory", root: tsm, resolved: filepath.Join(tsm, "tsm.db")},
+		{name: "TSM outside the root", root: filepath.Join(root, "cfg"), resolved: filepath.Join(root, "cfg", "server.properties"), ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkInsideRoot(test.root, test.resolved)
+			if test.ok && err != nil {
+				t.Fatalf("expected %s to be allowed, got %s", test.resolved, err)
+			}
+			if !test.ok && err == nil {
+				t.Fatalf("expected %s to be refused", test.resolved)
+			}
+		})
+	}
+}
+
+func TestCheckNotAboveTSM(t *testing.T) {
+	base, root := setupFileManager(t)
+	tests := []struct {
+		resolved string
+		ok       bool
+	}{
+		{resolved: filepath.Join(root, "cfg"), ok: true},
+		{resolved: filepath.Join(root, "game", "tsm", "tsm.db"), ok: true},
+		{resolved: filepath.Join(root, "game", "tsm")},
+		{resolved: filepath.Join(root, "game")},
+		{resolved: root},
+		{resolved: base},
+	}
+	for _, test := range tests {
+		err := checkNotAboveTSM(test.resolved)
+		if test.ok != (err == nil) {
+			t.Errorf("%s: got %v, want allowed %v", test.resolved, err, test.ok)
+		}
+	}
+}
+
+func TestRenameManagedFile(t *testing.T) {
+	base, root := setupFileManager(t)
+	tests := []struct {
+		name string
+		from string
+		to   string
+		err  error
+	}{
+		{name: "dot dot", from: "notes.txt", to: "../outside/notes.txt", err: ErrManagedNotFound},
+		{name: "dot dot from", from: "../outside/secret.txt", to: "secret.txt", err: ErrManagedNotFound},
+		{name: "absolute", from: "notes.txt", to: filepath.Join(base, "outside", "notes.txt"), err: ErrManagedNotFound},
+		{name: "through a symlink outside", from: "notes.txt", to: "escape/notes.txt", err: ErrOutsideRoot},
+		{name: "from through a symlink outside", from: "escape/secret.txt", to: "secret.txt", err: ErrOutsideRoot},
+		{name: "into TSM's directory", from: "notes.txt", to: "game/tsm/notes.txt", err: ErrOutsideRoot},
+		{name: "TSM's directory", from: "game/tsm", to: "tsm", err: ErrOutsideRoot},
+		{name: "above TSM's directory", from: "game", to: "game2", err: ErrOutsideRoot},
+		{name: "the root", from: "", to: "root2", err: ErrOutsideRoot},
+		{name: "onto the root", from: "notes.txt", to: "/", err: ErrOutsideRoot},
+		{name: "onto an existing file", from: "notes.txt", to: "cfg/server.properties", err: ErrManagedExists},
+		{name: "missing", from: "nope.txt", to: "nope2.txt", err: ErrManagedNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := RenameManagedFile(test.from, test.to); !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+	// nothing above should have moved anything
+	for _, path := range []string{"outside/secret.txt", "root/notes.txt", "root/game/tsm/tsm.db"} {
+		if !Exists(filepath.Join(base, path)) {
+			t.Fatalf("%s was moved", path)
+		}
+	}
+
+	// a symlink that leads outside is renamed itself, not what it points to
+	if err := RenameManagedFile("escape", "escape2"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "escape2")); err != nil || target != filepath.Join(base, "outside") {
+		t.Fatalf("symlink wasn't renamed: %q (%v)", target, err)
+	}
+	if err := RenameManagedFile("cfg-link/server.properties", "cfg/renamed.properties"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadManagedFile(t *testing.T) {
+	base, root := setupFileManager(t)
+	tests := []struct {
+		name     string
+		dir      string
+		fileName string
+		want     string // the path it's written to, relative to the root
+		err      bool
+	}{
+		{name: "plain", dir: "cfg", fileName: "a.txt", want: "cfg/a.txt"},
+		{name: "slashes", dir: "cfg", fileName: "sub/dir/b.txt", want: "cfg/b.txt"},
+		{name: "dot dot", dir: "cfg", fileName: "../../outside/c.txt", want: "cfg/c.txt"},
+		{name: "backslash dot dot", dir: "cfg", fileName: `..\..\outside\d.txt`, want: "cfg/d.txt"},
+		{name: "absolute", dir: "", fileName: filepath.Join(base, "outside", "e.txt"), want: "e.txt"},
+		{name: "dir with dot dot", dir: "../outside", fileName: "f.txt", err: true},
+		{name: "dir through a symlink outside", dir: "escape", fileName: "g.txt", err: true},
+		{name: "into TSM's directory", dir: "game/tsm", fileName: "h.txt", err: true},
+		{name: "dot dot name", dir: "cfg", fileName: "..", err: true},
+		{name: "dot name", dir: "cfg", fileName: ".", err: true},
+		{name: "slash name", dir: "cfg", fileName: "/", err: true},
+		{name: "empty name", dir: "cfg", fileName: "", err: true},
+		{name: "onto a symlink outside", dir: "", fileName: "secret-link", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rel, err := UploadManagedFile(test.dir, test.fileName, strings.NewReader("uploaded"))
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, wrote %s", rel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rel != test.want {
+				t.Fatalf("got %s, want %s", rel, test.want)
+			}
+			if data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(test.want))); err != nil || string(data) != "uploaded" {
+				t.Fatalf("upload wasn't written to %s: %q (%v)", test.want, data, err)
+			}
+		})
+	}
+
+	// nothing was written outside the root or into TSM's directory
+	for _, dir := range []string{filepath.Join(base, "outside"), filepath.Join(root, "game", "tsm")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("%s has %d files, want 1", dir, len(entries))
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(base, "outside", "secret.txt")); string(data) != "secret" {
+		t.Fatalf("file outside the root was overwritten: %q", data)
+	}
+}
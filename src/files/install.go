@@ -0,0 +1,66 @@
+package files
+
+// files/install.go is for backups of the game server's install directory (binaries, mods, config files), kept
+// separate from save backups so a bad update or mod can be undone without touching the save.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const installSourceName = "_install"
+
+var errInstallDirIsTSM = errors.New("install directory is TSM's own directory, set install_dir to back it up")
+
+// GetInstallDir returns the game server's install directory.
+func GetInstallDir() string {
+	if Config.InstallDir != "" {
+		return Config.InstallDir
+	}
+	return filepath.Dir(Config.GameExePath)
+}
+
+// installSource returns the install directory as a backup source, leaving out install_backup_exclude. If TSM itself
+// lives inside the install directory its folder is left out too, so restoring can't remove TSM's own files. It's
+// checked here rather than on startup since plenty of setups never take install backups or snapshots.
+func installSource() (BackupSource, error) {
+	installDir, err := filepath.Abs(GetInstallDir())
+	if err != nil {
+		return BackupSource{}, err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return BackupSource{}, err
+	}
+	source := BackupSource{Name: installSourceName, Path: installDir, Exclude: append([]string{}, Config.InstallBackupExclude...)}
+	if rel, ok := relativeInside(installDir, workDir); ok {
+		if rel == "." {
+			return BackupSource{}, errInstallDirIsTSM
+		}
+		source.Exclude = append(source.Exclude, rel)
+	}
+	if err := validateBackupSources([]BackupSource{source}); err != nil {
+		return BackupSource{}, err
+	}
+	return source, nil
+}
+
+// relativeInside returns path relative to dir (slash separated) if it's dir or inside it.
+func relativeInside(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// CreateInstallBackup backs up the install directory. Assumes server is stopped.
+func CreateInstallBackup(comment string, progress Progress) error {
+	source, err := installSource()
+	if err != nil {
+		return err
+	}
+	return createBackup(BackupKindInstall, comment, []BackupSource{source}, progress)
+}
@@ -0,0 +1,70 @@
+package files
+
+// files/jobs.go is for the persisted state of long running operations (backups, restores, updates).
+// The jobs themselves are run by the game package.
+
+import "errors"
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Progress is told how far along a long running operation is. Anywhere one's accepted nil is fine.
+type Progress interface {
+	// SetTotal sets how many bytes and files the operation expects to process, 0 if unknown.
+	SetTotal(bytes, files int64)
+	// Advance adds to the bytes and files processed so far.
+	Advance(bytes, files int64)
+	// Logf adds a line to the operation's log.
+	Logf(format string, args ...any)
+	// Write appends raw output from commands the operation runs, so it can be a command's stdout and stderr.
+	Write(p []byte) (int, error)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) SetTotal(bytes, files int64)     {}
+func (nopProgress) Advance(bytes, files int64)      {}
+func (nopProgress) Logf(format string, args ...any) {}
+func (nopProgress) Write(p []byte) (int, error)     { return len(p), nil }
+
+// orNop returns progress, or a Progress that ignores everything if it's nil.
+func orNop(progress Progress) Progress {
+	if progress == nil {
+		return nopProgress{}
+	}
+	return progress
+}
+
+// GetJob returns the job with the given ID as last saved, or ErrJobNotFound.
+func GetJob(ID uint) (Job, error) {
+	var job Job
+	result := DB.Limit(1).Find(&job, ID)
+	if result.Error != nil {
+		return Job{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// GetRecentJobs returns up to limit jobs, newest first, without their logs and output which can be large.
+func GetRecentJobs(limit int) ([]Job, error) {
+	jobs := []Job{}
+	result := DB.Omit("log", "output").Order("id desc").Limit(limit).Find(&jobs)
+	return jobs, result.Error
+}
+
+// FailInterruptedJobs marks jobs left queued or running by the last run as failed, they'll never finish.
+// Returns how many there were.
+func FailInterruptedJobs() (int64, error) {
+	result := DB.Model(&Job{}).Where("status IN ?", []string{JobQueued, JobRunning}).
+		Updates(map[string]any{"status": JobFailed, "error": "interrupted by tsm shutting down"})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,47 @@
+package files
+
+// files/maintenance.go is for remembering maintenance mode across restarts, see game/maintenance.go.
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+const maintenancePath = "maintenance.json"
+
+// MaintenanceMode is whether the server's down for maintenance, and why.
+type MaintenanceMode struct {
+	Enabled bool
+	Reason  string
+	Since   time.Time
+}
+
+// LoadMaintenanceMode returns the saved maintenance mode, off if it's never been turned on.
+func LoadMaintenanceMode() (MaintenanceMode, error) {
+	var mode MaintenanceMode
+	data, err := os.ReadFile(maintenancePath)
+	if os.IsNotExist(err) {
+		return mode, nil
+	}
+	if err != nil {
+		return mode, err
+	}
+	err = json.Unmarshal(data, &mode)
+	return mode, err
+}
+
+// SaveMaintenanceMode saves maintenance mode so it's still on if TSM restarts.
+func SaveMaintenanceMode(mode MaintenanceMode) error {
+	if !mode.Enabled {
+		if err := os.Remove(maintenancePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(mode)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(maintenancePath, data, 0644)
+}
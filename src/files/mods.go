@@ -0,0 +1,314 @@
+package files
+
+// files/mods.go is for managing mods. Uploaded mods are kept in TSM's mod library, and enabling one symlinks (or
+// moves) it into the game's mods directory. The enabled mods can optionally be written to a load order file.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ModModeSymlink = "symlink"
+	ModModeMove    = "move"
+)
+
+var (
+	ErrModNotFound  = errors.New("mod not found")
+	ErrModsDisabled = errors.New("mods_dir isn't set")
+	ErrModExists    = errors.New("a mod with that file name already exists")
+
+	ModLibraryPath string
+	modsMutex      sync.Mutex
+)
+
+// InitMods creates the mod library and makes sure the mods directory exists, if mods are configured.
+func InitMods() {
+	ModLibraryPath = "mods"
+	if Config.ModsDir == "" {
+		return
+	}
+	if mode := modsEnableMode(); mode != ModModeSymlink && mode != ModModeMove {
+		panic("mods_enable_mode must be symlink or move")
+	}
+	if _, err := CreateDirIfNotExists(ModLibraryPath); err != nil {
+		panic(err)
+	}
+	if _, err := CreateDirIfNotExists(Config.ModsDir); err != nil {
+		panic(err)
+	}
+}
+
+// modsEnableMode returns the configured mods_enable_mode, symlink if it isn't set.
+func modsEnableMode() string {
+	if Config.ModsEnableMode == "" {
+		return ModModeSymlink
+	}
+	return Config.ModsEnableMode
+}
+
+// GetMods returns every mod in the library in load order.
+func GetMods() ([]Mod, error) {
+	mods := []Mod{}
+	result := DB.Order("position, id").Find(&mods)
+	return mods, result.Error
+}
+
+func getMod(ID uint) (Mod, error) {
+	var mod Mod
+	if err := DB.First(&mod, ID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return mod, ErrModNotFound
+		}
+		return mod, err
+	}
+	return mod, nil
+}
+
+// cleanModFileName makes sure an uploaded file name is just a name, not a path.
+func cleanModFileName(name string) (string, error) {
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, `\`, "/")))
+	if name == "/" || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid mod file name: %q", name)
+	}
+	return name, nil
+}
+
+func (mod Mod) libraryPath() string {
+	return filepath.Join(ModLibraryPath, mod.FileName)
+}
+
+func (mod Mod) activePath() string {
+	return filepath.Join(Config.ModsDir, mod.FileName)
+}
+
+// AddMod stores a new mod in the library, disabled and last in the load order.
+func AddMod(fileName string, r io.Reader) (Mod, error) {
+	if Config.ModsDir == "" {
+		return Mod{}, ErrModsDisabled
+	}
+	fileName, err := cleanModFileName(fileName)
+	if err != nil {
+		return Mod{}, err
+	}
+	if err := CheckDiskSpace(ModLibraryPath, 0); err != nil {
+		return Mod{}, err
+	}
+
+	modsMutex.Lock()
+	defer modsMutex.Unlock()
+
+	var count int64
+	if err := DB.Model(&Mod{}).Where("file_name = ?", fileName).Count(&count).Error; err != nil {
+		return Mod{}, err
+	}
+	if count > 0 || Exists(filepath.Join(ModLibraryPath, fileName)) {
+		return Mod{}, ErrModExists
+	}
+
+	// write it to the library, hashing as it goes
+	mod := Mod{FileName: fileName}
+	outFile, err := os.OpenFile(mod.libraryPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return Mod{}, err
+	}
+	digest, err := hashReader(io.TeeReader(r, outFile))
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(mod.libraryPath())
+		return Mod{}, err
+	}
+	mod.Size = digest.Size
+	mod.Checksum = digest.Hash
+
+	var last Mod
+	if err := DB.Order("position desc").Limit(1).Find(&last).Error; err != nil {
+		os.Remove(mod.libraryPath())
+		return Mod{}, err
+	}
+	mod.Position = last.Position + 1
+	if err := DB.Create(&mod).Error; err != nil {
+		os.Remove(mod.libraryPath())
+		return Mod{}, err
+	}
+	return mod, nil
+}
+
+// EnableMod links (or moves) the mod into the mods directory.
+func EnableMod(ID uint) (Mod, error) {
+	modsMutex.Lock()
+	defer modsMutex.Unlock()
+
+	mod, err := getMod(ID)
+	if err != nil || mod.Enabled {
+		return mod, err
+	}
+	if _, err := os.Lstat(mod.activePath()); err == nil {
+		return mod, fmt.Errorf("%s is already in the mods directory and isn't managed by TSM", mod.FileName)
+	}
+
+	mode := modsEnableMode()
+	switch mode {
+	case ModModeMove:
+		err = moveFile(mod.libraryPath(), mod.activePath())
+	default:
+		var target string
+		if target, err = filepath.Abs(mod.libraryPath()); err == nil {
+			err = os.Symlink(target, mod.activePath())
+		}
+	}
+	if err != nil {
+		return mod, err
+	}
+
+	mod.Enabled = true
+	mod.EnabledMode = mode
+	if err := DB.Save(&mod).Error; err != nil {
+		return mod, err
+	}
+	return mod, writeLoadOrder()
+}
+
+// DisableMod takes the mod back out of the mods directory.
+func DisableMod(ID uint) (Mod, error) {
+	modsMutex.Lock()
+	defer modsMutex.Unlock()
+
+	mod, err := getMod(ID)
+	if err != nil || !mod.Enabled {
+		return mod, err
+	}
+	if err := deactivateMod(mod); err != nil {
+		return mod, err
+	}
+	mod.Enabled = false
+	mod.EnabledMode = ""
+	if err := DB.Save(&mod).Error; err != nil {
+		return mod, err
+	}
+	return mod, writeLoadOrder()
+}
+
+// deactivateMod removes the link to the mod, or moves it back to the library.
+func deactivateMod(mod Mod) error {
+	if mod.EnabledMode == ModModeMove {
+		return moveFile(mod.activePath(), mod.libraryPath())
+	}
+	info, err := os.Lstat(mod.activePath())
+	if os.IsNotExist(err) {
+		return nil // already gone
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s in the mods directory isn't a link TSM made, leaving it alone", mod.FileName)
+	}
+	return os.Remove(mod.activePath())
+}
+
+// DeleteMod disables the mod if needed and removes it from the library.
+func DeleteMod(ID uint) error {
+	if _, err := DisableMod(ID); err != nil {
+		return err
+	}
+
+	modsMutex.Lock()
+	defer modsMutex.Unlock()
+	mod, err := getMod(ID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(mod.libraryPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return DB.Unscoped().Delete(&mod).Error
+}
+
+// SetModOrder sets the load order, IDs first to last. Mods left out keep their order after the given ones.
+func SetModOrder(IDs []uint) error {
+	modsMutex.Lock()
+	defer modsMutex.Unlock()
+
+	mods, err := GetMods()
+	if err != nil {
+		return err
+	}
+	positions := map[uint]int{}
+	for i, ID := range IDs {
+		positions[ID] = i + 1
+	}
+	next := len(IDs) + 1
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for _, mod := range mods {
+			position, ok := positions[mod.ID]
+			if !ok {
+				position = next
+				next++
+			}
+			if err := tx.Model(&mod).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeLoadOrder()
+}
+
+// writeLoadOrder rewrites the load order file with the enabled mods, if there is one.
+func writeLoadOrder() error {
+	if Config.ModLoadOrderFile == "" {
+		return nil
+	}
+	mods, err := GetMods()
+	if err != nil {
+		return err
+	}
+	var builder strings.Builder
+	for _, mod := range mods {
+		if !mod.Enabled {
+			continue
+		}
+		name := strings.TrimSuffix(mod.FileName, filepath.Ext(mod.FileName))
+		line := strings.NewReplacer("{file}", mod.FileName, "{name}", name).Replace(Config.ModLoadOrderFormat)
+		builder.WriteString(line + "\n")
+	}
+	return os.WriteFile(Config.ModLoadOrderFile, []byte(builder.String()), 0644)
+}
+
+// moveFile renames src to dst, copying across filesystems if it has to.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
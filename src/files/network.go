@@ -3,26 +3,95 @@ package files
 // files/network.go is for handling file uploads/downloads.
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
-func SendFileToClient(w http.ResponseWriter, filePath string) error {
+// SendFileToClient serves the file as a download. Content-Length, Last-Modified and Range requests are handled
+// by http.ServeContent, so interrupted downloads can be resumed. Set an ETag header first to enable If-Range etc.
+func SendFileToClient(w http.ResponseWriter, r *http.Request, filePath string) error {
 	// Open the file to be sent
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
 	// Set the appropriate headers
 	fileName := filepath.Base(filePath)
 	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
 	w.Header().Set("Content-Type", "application/octet-stream")
 
-	// Stream the file to the response
-	_, err = io.Copy(w, file)
+	// Serve the file, or the requested range of it
+	http.ServeContent(w, r, fileName, info.ModTime(), file)
+	return nil
+}
+
+// SendBackupToClient serves the backup's archive as is, using its stored checksum as the ETag.
+func SendBackupToClient(w http.ResponseWriter, r *http.Request, backup Backup) error {
+	if backup.Checksum != "" {
+		w.Header().Set("ETag", `"`+backup.Checksum+`"`)
+	}
+	return SendFileToClient(w, r, backup.Path)
+}
+
+// SendLiveSaveToClient archives the live backup sources straight to the client without writing anything to disk.
+// An empty format uses the configured backup format. Assumes server is stopped.
+func SendLiveSaveToClient(w http.ResponseWriter, format string) error {
+	if format == "" {
+		format = Config.BackupFormat
+	}
+	archiver, err := GetArchiver(format)
+	if err != nil {
+		return err
+	}
+	sources := GetBackupSources()
+	for _, source := range sources {
+		if !Exists(source.Path) {
+			return errors.New("backup source " + source.Name + " does not exist")
+		}
+	}
+
+	// Set the appropriate headers
+	fileName := "live_" + time.Now().Format("2006-01-02_15-04-05") + "." + archiver.Extension()
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// Stream the archive to the response
+	aw, err := archiver.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	manifest := BackupManifest{Version: 1, Created: time.Now(), Sources: sources}
+	if err := archiveSources(aw, manifest, nil); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// SendDecryptedBackupToClient streams the decrypted archive of an encrypted backup to the client.
+func SendDecryptedBackupToClient(w http.ResponseWriter, backup Backup) error {
+	reader, err := openBackup(backup)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Set the appropriate headers, dropping the encrypted extension from the name
+	fileName := strings.TrimSuffix(filepath.Base(backup.Path), encryptedExtension)
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// Stream the decrypted archive to the response
+	_, err = io.Copy(w, reader)
 	return err
 }
@@ -0,0 +1,28 @@
+package files
+
+// files/playerHistory.go is for the history of how many players were online, recorded by the server query.
+
+import "time"
+
+func playerHistoryDays() int {
+	if Config.PlayerHistoryDays <= 0 {
+		return 30
+	}
+	return Config.PlayerHistoryDays
+}
+
+// RecordPlayerCount records how many players are online now, and forgets counts older than player_history_days.
+func RecordPlayerCount(players, maxPlayers int) error {
+	if err := DB.Create(&PlayerCountSample{Players: players, MaxPlayers: maxPlayers}).Error; err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -playerHistoryDays())
+	return DB.Where("created_at < ?", cutoff).Delete(&PlayerCountSample{}).Error
+}
+
+// GetPlayerCountHistory returns the player counts recorded since the given time, oldest first.
+func GetPlayerCountHistory(since time.Time) ([]PlayerCountSample, error) {
+	samples := []PlayerCountSample{}
+	result := DB.Where("created_at >= ?", since).Order("created_at").Find(&samples)
+	return samples, result.Error
+}
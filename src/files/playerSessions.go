@@ -0,0 +1,59 @@
+package files
+
+// files/playerSessions.go is for the history of who was on the server and when, see game/playerTracking.go.
+
+import "time"
+
+// reasons a session ended
+const (
+	PlayerLeft          = "left"
+	PlayerServerStopped = "server stopped"
+	PlayerTSMStopped    = "tsm stopped" // TSM wasn't running to see them leave, so the leave time is a guess
+)
+
+// StartPlayerSession records a player joining.
+func StartPlayerSession(name, playerID string, joinedAt time.Time) (PlayerSession, error) {
+	session := PlayerSession{Name: name, PlayerID: playerID, JoinedAt: joinedAt}
+	err := DB.Create(&session).Error
+	return session, err
+}
+
+// EndPlayerSession records a player leaving.
+func EndPlayerSession(session *PlayerSession, leftAt time.Time, reason string) error {
+	session.LeftAt = &leftAt
+	session.DurationSecs = int64(leftAt.Sub(session.JoinedAt).Seconds())
+	session.EndReason = reason
+	return DB.Save(session).Error
+}
+
+// TouchOpenPlayerSessions marks every open session as still going, so if TSM dies there's a rough leave time.
+func TouchOpenPlayerSessions() error {
+	return DB.Model(&PlayerSession{}).Where("left_at IS NULL").Update("updated_at", time.Now()).Error
+}
+
+// EndOpenPlayerSessions ends every session that's still open, e.g. ones left open when TSM last stopped. They're
+// ended at their last update since that's the last time anything was known about them.
+func EndOpenPlayerSessions(reason string) (int, error) {
+	sessions := []PlayerSession{}
+	if err := DB.Where("left_at IS NULL").Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+	for i := range sessions {
+		if err := EndPlayerSession(&sessions[i], sessions[i].UpdatedAt, reason); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
+
+// GetPlayerSessions returns sessions that overlap from and to, newest first. name filters by player name (or ID)
+// if it's not empty.
+func GetPlayerSessions(from, to time.Time, name string, limit int) ([]PlayerSession, error) {
+	sessions := []PlayerSession{}
+	query := DB.Where("joined_at <= ? AND (left_at IS NULL OR left_at >= ?)", to, from)
+	if name != "" {
+		query = query.Where("name LIKE ? OR player_id = ?", "%"+name+"%", name)
+	}
+	result := query.Order("joined_at desc").Limit(limit).Find(&sessions)
+	return sessions, result.Error
+}
@@ -0,0 +1,116 @@
+package files
+
+// files/presets.go is for game presets, config defaults for popular dedicated servers that are built into TSM.
+// Choosing one with "preset" in config.json fills in anything the config leaves empty.
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed presets/*.json
+var presetFiles embed.FS
+
+// Preset is a set of config defaults for a game.
+type Preset struct {
+	Name   string          `json:"name"` // file name without .json, what "preset" is set to
+	Title  string          `json:"title"`
+	Notes  string          `json:"notes"`  // anything that has to be set up by hand
+	Config json.RawMessage `json:"config"` // config.json keys, {install_dir} and {home} are replaced
+}
+
+// GetPresets returns every built in preset, sorted by name.
+func GetPresets() ([]Preset, error) {
+	entries, err := presetFiles.ReadDir("presets")
+	if err != nil {
+		return nil, err
+	}
+	presets := []Preset{}
+	for _, entry := range entries {
+		preset, err := GetPreset(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets, nil
+}
+
+// GetPreset returns the preset with the given name.
+func GetPreset(name string) (Preset, error) {
+	data, err := presetFiles.ReadFile(path.Join("presets", path.Base(name)+".json"))
+	if err != nil {
+		return Preset{}, fmt.Errorf("unknown preset %q", name)
+	}
+	var preset Preset
+	if err := json.Unmarshal(data, &preset); err != nil {
+		return Preset{}, fmt.Errorf("invalid preset %s: %w", name, err)
+	}
+	preset.Name = name
+	return preset, nil
+}
+
+// expandPreset returns the preset's config with {install_dir} and {home} filled in.
+func expandPreset(preset Preset, installDir string) ([]byte, error) {
+	home, _ := os.UserHomeDir()
+	values := map[string]string{"{install_dir}": installDir, "{home}": home}
+	config := string(preset.Config)
+	for placeholder, value := range values {
+		if !strings.Contains(config, placeholder) {
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("preset %s needs %s to be set", preset.Name, strings.Trim(placeholder, "{}"))
+		}
+		// the value goes inside a JSON string, so escape it like one
+		quoted, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		config = strings.ReplaceAll(config, placeholder, string(quoted[1:len(quoted)-1]))
+	}
+	return []byte(config), nil
+}
+
+// withoutEmptyValues drops keys set to "", [] or null, so they don't override the preset. Other values, including
+// 0 and false, are kept since they might be deliberate.
+func withoutEmptyValues(file []byte) ([]byte, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(file, &values); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		switch strings.Join(strings.Fields(string(value)), "") {
+		case `""`, "[]", "null":
+			delete(values, key)
+		}
+	}
+	return json.Marshal(values)
+}
+
+// applyPreset reloads the config file on top of the preset it selects. Call after the file is loaded once.
+func applyPreset(file []byte) error {
+	preset, err := GetPreset(Config.Preset)
+	if err != nil {
+		return err
+	}
+	presetConfig, err := expandPreset(preset, Config.InstallDir)
+	if err != nil {
+		return err
+	}
+	overrides, err := withoutEmptyValues(file)
+	if err != nil {
+		return err
+	}
+	Config = ConfigInterface{}
+	if err := json.Unmarshal(presetConfig, &Config); err != nil {
+		return fmt.Errorf("invalid preset %s: %w", preset.Name, err)
+	}
+	return json.Unmarshal(overrides, &Config)
+}
@@ -0,0 +1,324 @@
+package files
+
+// files/replication.go is for copying backups to offsite targets after they're created.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+const (
+	ReplicaPending  = "pending"
+	ReplicaUploaded = "uploaded"
+	ReplicaFailed   = "failed"
+)
+
+// Replicator is implemented by each type of replication target.
+type Replicator interface {
+	// Upload copies the local file to the target under remoteName.
+	Upload(localPath, remoteName string) error
+	// Download writes the target's copy of remoteName to w.
+	Download(remoteName string, w io.Writer) error
+}
+
+var (
+	ErrNoRemoteCopy       = errors.New("backup has no uploaded remote copy")
+	replicators           = map[string]Replicator{} // keyed by target name
+	replicationWake       = make(chan struct{}, 1)
+	closeReplicationReq   = make(chan bool)
+	closeReplicationAck   = make(chan bool)
+	replicationRetryCheck = time.Minute
+)
+
+func newReplicator(target ReplicationTarget) (Replicator, error) {
+	switch target.Type {
+	case "dir":
+		if target.Path == "" {
+			return nil, errors.New("dir replication target needs a path")
+		}
+		return dirReplicator{dir: target.Path}, nil
+	case "s3":
+		return newS3Replicator(target)
+	case "sftp":
+		return newSftpReplicator(target)
+	default:
+		return nil, fmt.Errorf("unknown replication target type: %s", target.Type)
+	}
+}
+
+// InitReplication sets up the configured replication targets and starts the upload worker.
+func InitReplication() {
+	replicators = map[string]Replicator{}
+	for _, target := range Config.ReplicationTargets {
+		if target.Name == "" {
+			panic("replication target is missing a name")
+		}
+		if _, ok := replicators[target.Name]; ok {
+			panic("duplicate replication target name: " + target.Name)
+		}
+		replicator, err := newReplicator(target)
+		if err != nil {
+			panic(err)
+		}
+		replicators[target.Name] = replicator
+	}
+	startReplicationWorker()
+}
+
+func StopReplication() {
+	closeReplicationReq <- true
+	<-closeReplicationAck
+}
+
+// ReplicationTargetNames returns the names of the configured replication targets, in config order.
+func ReplicationTargetNames() []string {
+	names := []string{}
+	for _, target := range Config.ReplicationTargets {
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+func wakeReplicationWorker() {
+	select {
+	case replicationWake <- struct{}{}:
+	default: // already awake
+	}
+}
+
+// queueReplication creates a pending replica of the backup for every configured target.
+func queueReplication(backup Backup) error {
+	for _, name := range ReplicationTargetNames() {
+		replica := BackupReplica{
+			BackupID:    backup.ID,
+			Target:      name,
+			Status:      ReplicaPending,
+			NextAttempt: time.Now(),
+		}
+		if err := DB.Create(&replica).Error; err != nil {
+			return err
+		}
+	}
+	wakeReplicationWorker()
+	return nil
+}
+
+// RetryReplication queues the backup for upload to every target it isn't already uploaded to, resetting attempts.
+func RetryReplication(backup Backup) error {
+	for _, name := range ReplicationTargetNames() {
+		var replica BackupReplica
+		result := DB.Where("backup_id = ? AND target = ?", backup.ID, name).Limit(1).Find(&replica)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			replica = BackupReplica{BackupID: backup.ID, Target: name}
+		} else if replica.Status == ReplicaUploaded {
+			continue
+		}
+		replica.Status = ReplicaPending
+		replica.Attempts = 0
+		replica.NextAttempt = time.Now()
+		if err := DB.Save(&replica).Error; err != nil {
+			return err
+		}
+	}
+	wakeReplicationWorker()
+	return nil
+}
+
+// GetBackupReplicas returns the replication state of the backup on every target.
+func GetBackupReplicas(backup Backup) ([]BackupReplica, error) {
+	replicas := []BackupReplica{}
+	result := DB.Where("backup_id = ?", backup.ID).Find(&replicas)
+	return replicas, result.Error
+}
+
+// uploadReplica uploads a single replica and records the outcome.
+func uploadReplica(replica BackupReplica) {
+	replicator, ok := replicators[replica.Target]
+	if !ok {
+		return // target was removed from the config
+	}
+	var backup Backup
+	err := DB.Where("id = ?", replica.BackupID).First(&backup).Error
+	if err == nil {
+		err = replicator.Upload(backup.Path, backup.Name)
+	}
+
+	replica.Attempts++
+	if err != nil {
+		blog.Error(fmt.Sprintf("Failed to replicate backup %d to %s (attempt %d): %s", replica.BackupID, replica.Target, replica.Attempts, err.Error()))
+		replica.Status = ReplicaFailed
+		replica.LastError = err.Error()
+		// back off quadratically, 1, 4, 9, ... minutes
+		replica.NextAttempt = time.Now().Add(time.Duration(replica.Attempts*replica.Attempts) * time.Minute)
+	} else {
+		blog.Info(fmt.Sprintf("Replicated backup %s to %s", backup.Name, replica.Target))
+		now := time.Now()
+		replica.Status = ReplicaUploaded
+		replica.LastError = ""
+		replica.UploadedAt = &now
+	}
+	if err := DB.Save(&replica).Error; err != nil {
+		blog.Error(err.Error())
+	}
+}
+
+// replicateDue uploads every pending replica and every failed one that's due for a retry.
+func replicateDue() {
+	maxAttempts := Config.ReplicationMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	var replicas []BackupReplica
+	result := DB.Where("status IN ? AND attempts < ? AND next_attempt <= ?",
+		[]string{ReplicaPending, ReplicaFailed}, maxAttempts, time.Now()).Find(&replicas)
+	if result.Error != nil {
+		blog.Error(result.Error.Error())
+		return
+	}
+	for _, replica := range replicas {
+		uploadReplica(replica)
+	}
+}
+
+func startReplicationWorker() {
+	ticker := time.NewTicker(replicationRetryCheck)
+
+	go func() {
+		replicateDue() // anything left over from the last run
+		for {
+			select {
+			case <-ticker.C:
+				replicateDue()
+			case <-replicationWake:
+				replicateDue()
+			case <-closeReplicationReq:
+				ticker.Stop()
+				closeReplicationAck <- true
+				return
+			}
+		}
+	}()
+}
+
+// fetchRemoteBackup downloads the target's copy of the backup next to the local backups and checks it against
+// the stored checksum. Returns the path of the downloaded file, which the caller must remove.
+func fetchRemoteBackup(backup Backup, targetName string) (string, error) {
+	replicator, ok := replicators[targetName]
+	if !ok {
+		return "", fmt.Errorf("unknown replication target: %s", targetName)
+	}
+
+	outFile, err := os.CreateTemp(BackupsPath, ".remote-*")
+	if err != nil {
+		return "", err
+	}
+	err = replicator.Download(backup.Name, outFile)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && backup.Checksum != "" {
+		var file *os.File
+		if file, err = os.Open(outFile.Name()); err == nil {
+			var digest FileDigest
+			digest, err = hashReader(file)
+			file.Close()
+			if err == nil && digest.Hash != backup.Checksum {
+				err = errors.New("remote copy of backup doesn't match its checksum")
+			}
+		}
+	}
+	if err != nil {
+		os.Remove(outFile.Name())
+		return "", err
+	}
+	return outFile.Name(), nil
+}
+
+// RestoreBackupFromRemote restores the backup using the copy on the named target. Assumes server is stopped.
+func RestoreBackupFromRemote(backup Backup, targetName string, progress Progress) error {
+	blog.Info(fmt.Sprintf("Fetching backup %s from %s", backup.Name, targetName))
+	orNop(progress).Logf("Fetching backup from %s", targetName)
+	if err := CheckDiskSpace(BackupsPath, backup.Size); err != nil {
+		return err
+	}
+	tempPath, err := fetchRemoteBackup(backup, targetName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	backup.Path = tempPath
+	return RestoreBackup(backup, progress)
+}
+
+// firstRemoteCopy returns the name of the first configured target the backup has been uploaded to.
+func firstRemoteCopy(backup Backup) (string, error) {
+	replicas, err := GetBackupReplicas(backup)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range ReplicationTargetNames() {
+		for _, replica := range replicas {
+			if replica.Target == name && replica.Status == ReplicaUploaded {
+				return name, nil
+			}
+		}
+	}
+	return "", ErrNoRemoteCopy
+}
+
+// ==== Local mirror directory ================================================
+
+type dirReplicator struct {
+	dir string
+}
+
+func (d dirReplicator) Upload(localPath, remoteName string) error {
+	if _, err := CreateDirIfNotExists(d.dir); err != nil {
+		return err
+	}
+	source, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// write to a temp name first so a partial copy never looks complete
+	outPath := filepath.Join(d.dir, remoteName)
+	partPath := outPath + ".part"
+	outFile, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(outFile, source)
+	if err == nil {
+		err = outFile.Sync()
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, outPath)
+}
+
+func (d dirReplicator) Download(remoteName string, w io.Writer) error {
+	file, err := os.Open(filepath.Join(d.dir, remoteName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
@@ -0,0 +1,52 @@
+package files
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirReplicator(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mirror")
+	replicator, err := newReplicator(ReplicationTarget{Name: "usb", Type: "dir", Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, data := writeTestFile(t, 1000)
+
+	if err := replicator.Upload(path, "backup.zip"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "backup.zip" {
+		t.Fatalf("expected just backup.zip in the mirror, got %v", entries)
+	}
+
+	var downloaded bytes.Buffer
+	if err := replicator.Download("backup.zip", &downloaded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded.Bytes(), data) {
+		t.Fatal("downloaded copy doesn't match the upload")
+	}
+	if err := replicator.Download("missing.zip", &downloaded); err == nil {
+		t.Fatal("expected an error downloading a missing copy")
+	}
+}
+
+func TestNewReplicatorValidates(t *testing.T) {
+	for _, target := range []ReplicationTarget{
+		{Name: "a", Type: "dir"},
+		{Name: "b", Type: "s3", Endpoint: "https://s3.example.com", Bucket: "backups"},
+		{Name: "c", Type: "s3", Endpoint: "ftp://s3.example.com", Bucket: "backups", AccessKey: "a", SecretKey: "s"},
+		{Name: "d", Type: "ftp", Path: "/tmp"},
+	} {
+		if _, err := newReplicator(target); err == nil {
+			t.Errorf("expected target %s to be rejected", target.Name)
+		}
+	}
+}
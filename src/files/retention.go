@@ -0,0 +1,54 @@
+package files
+
+// files/retention.go is for deleting old backups once there are more of a kind than the config says to keep.
+
+import "os"
+
+// keepCount returns how many backups of the kind to keep, 0 keeps them all.
+func keepCount(kind string) int {
+	switch kind {
+	case BackupKindSave:
+		return Config.BackupKeepCount
+	case BackupKindInstall:
+		return Config.InstallBackupKeepCount
+	default:
+		return 0
+	}
+}
+
+// pruneBackups deletes the oldest backups of the kind past its keep count.
+func pruneBackups(kind string, progress Progress) error {
+	keep := keepCount(kind)
+	if keep <= 0 {
+		return nil
+	}
+	var backups []Backup
+	if err := DB.Where("kind = ?", kind).Order("id desc").Find(&backups).Error; err != nil {
+		return err
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	for _, backup := range backups[keep:] {
+		progress.Logf("Deleting old backup %s", backup.Name)
+		if err := DeleteBackup(backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBackup deletes the backup's file, its records and any share links to it. Copies on replication targets
+// are left alone.
+func DeleteBackup(backup Backup) error {
+	if err := os.Remove(backup.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := DB.Unscoped().Where("backup_id = ?", backup.ID).Delete(&BackupReplica{}).Error; err != nil {
+		return err
+	}
+	if err := DB.Unscoped().Where("backup_id = ?", backup.ID).Delete(&ShareLink{}).Error; err != nil {
+		return err
+	}
+	return DB.Unscoped().Delete(&backup).Error
+}
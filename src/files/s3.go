@@ -0,0 +1,344 @@
+package files
+
+// files/s3.go is the S3 compatible (AWS, MinIO, Backblaze B2, etc.) replication target.
+// Requests are signed with AWS Signature Version 4, using path style addressing. Files bigger than a part are sent
+// as a multipart upload, since a single PUT can't be over 5 GiB.
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3PartSize       = 64 << 20 // files bigger than this are uploaded in parts this size
+	s3MaxParts       = 10000    // S3's limit, parts get bigger for files that would need more
+	// uploads send at most a part per request, so this only trips if the endpoint stalls
+	s3RequestTimeout = 15 * time.Minute
+	// downloads can be any size, so they're given up on if nothing arrives for this long instead
+	s3IdleTimeout = 2 * time.Minute
+)
+
+type s3Replicator struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	partSize  int64
+	client    *http.Client
+}
+
+func newS3Replicator(target ReplicationTarget) (*s3Replicator, error) {
+	if target.Endpoint == "" || target.Bucket == "" || target.AccessKey == "" || target.SecretKey == "" {
+		return nil, errors.New("s3 replication target needs an endpoint, bucket, access key and secret key")
+	}
+	endpoint, err := url.Parse(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.New("s3 endpoint must start with http:// or https://")
+	}
+	region := target.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Replicator{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    target.Bucket,
+		prefix:    strings.Trim(target.Path, "/"),
+		accessKey: target.AccessKey,
+		secretKey: target.SecretKey,
+		partSize:  s3PartSize,
+		client:    &http.Client{},
+	}, nil
+}
+
+// objectURL returns the path style URL of the object, e.g. https://s3.example.com/bucket/prefix/name
+func (s *s3Replicator) objectURL(remoteName string) *url.URL {
+	objectURL := *s.endpoint
+	objectURL.Path = "/" + path.Join(strings.Trim(s.endpoint.Path, "/"), s.bucket, s.prefix, remoteName)
+	return &objectURL
+}
+
+func (s *s3Replicator) Upload(localPath, remoteName string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > s.partSize {
+		return s.uploadMultipart(file, info.Size(), remoteName)
+	}
+	_, err = s.putSection(io.NewSectionReader(file, 0, info.Size()), s.objectURL(remoteName))
+	return err
+}
+
+// putSection PUTs part of a file to the URL, returning the ETag S3 gives it.
+func (s *s3Replicator) putSection(section *io.SectionReader, target *url.URL) (string, error) {
+	// the payload hash is part of the signature, so hash the section first then rewind
+	digest, err := hashReader(section)
+	if err != nil {
+		return "", err
+	}
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), section)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = digest.Size
+	signS3Request(req, digest.Hash, s.accessKey, s.secretKey, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := s3ResponseError(resp); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadMultipart uploads the file in parts, aborting the upload if any of it fails so the parts don't linger
+// (and get billed) on the target.
+func (s *s3Replicator) uploadMultipart(file *os.File, size int64, remoteName string) error {
+	objectURL := s.objectURL(remoteName)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.send(http.MethodPost, objectURL, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return fmt.Errorf("couldn't start multipart upload: %w", err)
+	}
+	if initiated.UploadID == "" {
+		return errors.New("couldn't start multipart upload: no upload id in the response")
+	}
+
+	partSize := s.partSize
+	if parts := (size + partSize - 1) / partSize; parts > s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	completed := struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{}
+	err := func() error {
+		for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+			partURL := *objectURL
+			partURL.RawQuery = url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadID}}.Encode()
+			etag, err := s.putSection(io.NewSectionReader(file, offset, min(partSize, size-offset)), &partURL)
+			if err != nil {
+				return fmt.Errorf("part %d failed: %w", number, err)
+			}
+			completed.Parts = append(completed.Parts, s3CompletedPart{PartNumber: number, ETag: etag})
+		}
+		body, err := xml.Marshal(completed)
+		if err != nil {
+			return err
+		}
+		return s.send(http.MethodPost, objectURL, url.Values{"uploadId": {initiated.UploadID}}, body, nil)
+	}()
+	if err != nil {
+		if abortErr := s.send(http.MethodDelete, objectURL, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr != nil {
+			return errors.Join(err, fmt.Errorf("couldn't abort multipart upload: %w", abortErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// send makes a signed request with a small body, decoding the XML response into result if it isn't nil. S3 can
+// report errors in the body of a 200 when completing a multipart upload, so those are checked for too.
+func (s *s3Replicator) send(method string, target *url.URL, query url.Values, body []byte, result any) error {
+	requestURL := *target
+	requestURL.RawQuery = query.Encode()
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	signS3Request(req, hex.EncodeToString(hash[:]), s.accessKey, s.secretKey, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3ResponseError(resp); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var s3Error struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &s3Error) == nil {
+		return fmt.Errorf("s3 request failed: %s: %s", s3Error.Code, s3Error.Message)
+	}
+	if result != nil {
+		return xml.Unmarshal(data, result)
+	}
+	return nil
+}
+
+func (s *s3Replicator) Download(remoteName string, w io.Writer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := time.AfterFunc(s3IdleTimeout, cancel)
+	defer idle.Stop()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(remoteName).String(), nil)
+	if err != nil {
+		return err
+	}
+	signS3Request(req, emptyPayloadHash, s.accessKey, s.secretKey, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3ResponseError(resp); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, &idleReader{resp.Body, idle})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("s3 download stalled for %s", s3IdleTimeout)
+	}
+	return err
+}
+
+// idleReader pushes back its timer every time data arrives.
+type idleReader struct {
+	io.Reader
+	timer *time.Timer
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.Reader.Read(p)
+	if n > 0 {
+		ir.timer.Reset(s3IdleTimeout)
+	}
+	return n, err
+}
+
+func s3ResponseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("s3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath URI encodes each segment of the path as required by SigV4, leaving the slashes
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+// signS3Request adds the x-amz-date, x-amz-content-sha256 and Authorization headers to the request.
+// Every header already on the request is signed, along with host.
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// canonical headers, lowercase and sorted
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// canonical query string, sorted by key
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var queryParts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			queryParts = append(queryParts, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		strings.Join(queryParts, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
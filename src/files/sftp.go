@@ -0,0 +1,151 @@
+package files
+
+// files/sftp.go is the SFTP replication target.
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type sftpReplicator struct {
+	address   string
+	dir       string
+	sshConfig *ssh.ClientConfig
+}
+
+func newSftpReplicator(target ReplicationTarget) (*sftpReplicator, error) {
+	if target.Host == "" || target.User == "" {
+		return nil, errors.New("sftp replication target needs a host and user")
+	}
+	if target.Password == "" && target.KeyPath == "" {
+		return nil, errors.New("sftp replication target needs a password or key path")
+	}
+
+	// add the default port if there isn't one
+	address := target.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	// auth methods, key first
+	auth := []ssh.AuthMethod{}
+	if target.KeyPath != "" {
+		keyBytes, err := os.ReadFile(target.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if target.Password != "" {
+		auth = append(auth, ssh.Password(target.Password))
+	}
+
+	// host keys are always checked, add the server with `ssh-keyscan host >> ~/.ssh/known_hosts`
+	knownHostsPath := target.KnownHostsPath
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sftpReplicator{
+		address: address,
+		dir:     target.Path,
+		sshConfig: &ssh.ClientConfig{
+			User:            target.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// connect opens an ssh connection and an sftp session on it, closing the client closes both.
+func (s *sftpReplicator) connect() (*sftp.Client, func(), error) {
+	conn, err := ssh.Dial("tcp", s.address, s.sshConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return client, func() {
+		client.Close()
+		conn.Close()
+	}, nil
+}
+
+func (s *sftpReplicator) Upload(localPath, remoteName string) error {
+	source, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	client, closeClient, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	if s.dir != "" {
+		if err := client.MkdirAll(s.dir); err != nil {
+			return err
+		}
+	}
+
+	// write to a temp name first so a partial upload never looks complete
+	outPath := path.Join(s.dir, remoteName)
+	partPath := outPath + ".part"
+	outFile, err := client.Create(partPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(outFile, source)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		client.Remove(partPath)
+		return err
+	}
+	client.Remove(outPath) // plain sftp rename fails if the destination exists
+	return client.Rename(partPath, outPath)
+}
+
+func (s *sftpReplicator) Download(remoteName string, w io.Writer) error {
+	client, closeClient, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	file, err := client.Open(path.Join(s.dir, remoteName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
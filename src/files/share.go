@@ -0,0 +1,204 @@
+package files
+
+// files/share.go is for signed, expiring links that let someone without a login download a single backup.
+//
+// A link's token is "<link id>.<backup id>.<expiry unix>.<signature>", the signature being an HMAC over the rest
+// with a secret only this server knows. The link is also stored so it can be revoked and its downloads counted.
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	shareSecretPath = "share.key"
+	// how long a client has to resume a download it started through a link, from the last time it asked for part of it
+	shareResumeWindow = time.Hour
+)
+
+var (
+	ErrInvalidShareToken = errors.New("invalid share link")
+	ErrShareLinkExpired  = errors.New("share link has expired or been revoked")
+	ErrShareLinkNotFound = errors.New("share link not found")
+	shareSecret          []byte
+
+	shareResumes      = map[shareResume]time.Time{} // unfinished downloads and when they can no longer be resumed
+	shareResumesMutex sync.Mutex
+)
+
+// shareResume is a download started through a link that hasn't reached the end of the file yet.
+type shareResume struct {
+	linkID uint
+	ip     string
+}
+
+// InitShareLinks loads the share link signing secret, generating it on first run.
+// Deleting share.key invalidates every link.
+func InitShareLinks() {
+	secret, err := os.ReadFile(shareSecretPath)
+	if os.IsNotExist(err) {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(shareSecretPath, secret, 0600); err != nil {
+			panic(err)
+		}
+	} else if err != nil {
+		panic(err)
+	}
+	if len(secret) < 32 {
+		panic("share.key is too short, delete it to generate a new one")
+	}
+	shareSecret = secret
+}
+
+func signShareLink(linkID, backupID uint, exp int64) string {
+	mac := hmac.New(sha256.New, shareSecret)
+	fmt.Fprintf(mac, "%d.%d.%d", linkID, backupID, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token returns the token for the link's URL, /share/<token>
+func (link ShareLink) Token() string {
+	exp := link.Exp.Unix()
+	return fmt.Sprintf("%d.%d.%d.%s", link.ID, link.BackupID, exp, signShareLink(link.ID, link.BackupID, exp))
+}
+
+// Active returns true if the link can still be used to start a download.
+func (link ShareLink) Active() bool {
+	return !link.Revoked && link.Exp.After(time.Now()) && (link.MaxDownloads == 0 || link.Downloads < link.MaxDownloads)
+}
+
+// CreateShareLink creates a link to the backup that expires after the given duration. maxDownloads of 0 is unlimited.
+func CreateShareLink(backup Backup, duration time.Duration, maxDownloads int) (ShareLink, error) {
+	if duration <= 0 {
+		return ShareLink{}, errors.New("share link duration must be positive")
+	}
+	if maxDownloads < 0 {
+		return ShareLink{}, errors.New("max downloads can't be negative")
+	}
+	link := ShareLink{
+		BackupID:     backup.ID,
+		Exp:          time.Now().Add(duration).Truncate(time.Second), // the token only has second precision
+		MaxDownloads: maxDownloads,
+	}
+	result := DB.Create(&link)
+	return link, result.Error
+}
+
+// GetActiveShareLinks returns every link that can still be used, newest first.
+func GetActiveShareLinks() ([]ShareLink, error) {
+	links := []ShareLink{}
+	result := DB.Where("revoked = ? AND exp > ? AND (max_downloads = 0 OR downloads < max_downloads)", false, time.Now()).
+		Order("id desc").Find(&links)
+	return links, result.Error
+}
+
+// RevokeShareLink stops the link from working.
+func RevokeShareLink(ID uint) error {
+	result := DB.Model(&ShareLink{}).Where("id = ?", ID).Update("revoked", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// ResolveShareToken checks the token's signature and that its link is still usable, returning the link and its backup.
+// resumeIP is the client's IP if the request picks up part way through the file, "" otherwise. If that IP has an
+// unfinished download through the link it's a resume, which works even once the link's used up and doesn't count as
+// another download. Returns ErrInvalidShareToken if the token was tampered with, ErrShareLinkExpired if it's no
+// longer usable.
+func ResolveShareToken(token, resumeIP string) (link ShareLink, backup Backup, resume bool, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return ShareLink{}, Backup{}, false, ErrInvalidShareToken
+	}
+	linkID, err1 := strconv.ParseUint(parts[0], 10, 64)
+	backupID, err2 := strconv.ParseUint(parts[1], 10, 64)
+	exp, err3 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return ShareLink{}, Backup{}, false, ErrInvalidShareToken
+	}
+	expected := signShareLink(uint(linkID), uint(backupID), exp)
+	if !hmac.Equal([]byte(parts[3]), []byte(expected)) {
+		return ShareLink{}, Backup{}, false, ErrInvalidShareToken
+	}
+
+	// signature's good, so anything past here is a stale link rather than someone guessing
+	result := DB.Limit(1).Find(&link, uint(linkID))
+	if result.Error != nil {
+		return ShareLink{}, Backup{}, false, result.Error
+	}
+	if result.RowsAffected == 0 || link.BackupID != uint(backupID) || link.Revoked || !link.Exp.After(time.Now()) {
+		return ShareLink{}, Backup{}, false, ErrShareLinkExpired
+	}
+	if backup, err = GetBackup(strconv.FormatUint(backupID, 10)); err != nil {
+		return ShareLink{}, Backup{}, false, err
+	}
+	// encrypted backups are decrypted on the fly and always sent whole, so there's nothing to resume
+	resume = resumeIP != "" && !backup.Encrypted && resumeShareDownload(link.ID, resumeIP)
+	if !resume && !link.Active() {
+		return ShareLink{}, Backup{}, false, ErrShareLinkExpired
+	}
+	return link, backup, resume, nil
+}
+
+// resumeShareDownload returns true if the IP has an unfinished download through the link, giving it longer to finish.
+func resumeShareDownload(linkID uint, ip string) bool {
+	shareResumesMutex.Lock()
+	defer shareResumesMutex.Unlock()
+	key := shareResume{linkID, ip}
+	if until, ok := shareResumes[key]; !ok || time.Now().After(until) {
+		return false
+	}
+	shareResumes[key] = time.Now().Add(shareResumeWindow)
+	return true
+}
+
+// FinishShareDownload is called once a download through the link reaches the end of the file, so it can't be
+// "resumed" again.
+func FinishShareDownload(link ShareLink, ip string) {
+	shareResumesMutex.Lock()
+	defer shareResumesMutex.Unlock()
+	delete(shareResumes, shareResume{link.ID, ip})
+}
+
+// CountShareDownload records a download of the link by ip, failing if it's used up its downloads in the meantime. The
+// same IP can resume it until it's finished.
+func CountShareDownload(link ShareLink, ip string) error {
+	result := DB.Model(&ShareLink{}).
+		Where("id = ? AND (max_downloads = 0 OR downloads < max_downloads)", link.ID).
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareLinkExpired
+	}
+
+	shareResumesMutex.Lock()
+	defer shareResumesMutex.Unlock()
+	now := time.Now()
+	for key, until := range shareResumes {
+		if now.After(until) {
+			delete(shareResumes, key)
+		}
+	}
+	shareResumes[shareResume{link.ID, ip}] = now.Add(shareResumeWindow)
+	return nil
+}
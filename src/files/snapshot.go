@@ -0,0 +1,97 @@
+package files
+
+// files/snapshot.go is for snapshots of the install directory and save taken before updates, so a bad update can
+// be rolled back. Snapshots are regular backup archives, but aren't listed with the backups.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// snapshotSources returns the install directory followed by any backup sources it doesn't already cover. If the
+// install directory is TSM's own directory (the default when the game exe sits next to TSM) it can't be restored
+// safely, so only the backup sources are snapshotted.
+func snapshotSources(progress Progress) ([]BackupSource, error) {
+	install, err := installSource()
+	if errors.Is(err, errInstallDirIsTSM) {
+		message := "Install directory is TSM's own directory, so only the save is snapshotted. Set install_dir to roll back the server's files too"
+		progress.Logf(message)
+		blog.Warn(message)
+	} else if err != nil {
+		return nil, err
+	}
+
+	sources := []BackupSource{}
+	if install.Path != "" {
+		sources = append(sources, install)
+	}
+	for _, source := range GetBackupSources() {
+		if source.Name == installSourceName {
+			return nil, errors.New("backup source name " + installSourceName + " is reserved for snapshots")
+		}
+		if install.Path == "" {
+			sources = append(sources, source)
+			continue
+		}
+		sourcePath, err := filepath.Abs(source.Path)
+		if err != nil {
+			return nil, err
+		}
+		// sources inside the install directory are already covered, unless they're excluded from it
+		if rel, ok := relativeInside(install.Path, sourcePath); !ok || matchesAny(install.Exclude, rel) {
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+// CreateSnapshot archives the install directory and save to name in the backups directory, replacing any previous
+// snapshot with that name. The returned backup isn't added to the database. Assumes server is stopped.
+func CreateSnapshot(name string, progress Progress) (Backup, error) {
+	archiver, err := GetArchiver(Config.BackupFormat)
+	if err != nil {
+		return Backup{}, err
+	}
+	sources, err := snapshotSources(progress)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	outName := name + "." + archiver.Extension()
+	if EncryptionEnabled() {
+		outName += encryptedExtension
+	}
+	outPath := filepath.Join(BackupsPath, outName)
+	checksum, size, err := writeArchive(archiver, sources, outPath, progress)
+	if err != nil {
+		return Backup{}, err
+	}
+	return Backup{
+		Path:      outPath,
+		Name:      outName,
+		Format:    archiver.Extension(),
+		Encrypted: EncryptionEnabled(),
+		Checksum:  checksum,
+		Size:      size,
+	}, nil
+}
+
+// RestoreSnapshot puts the install directory and save back the way they were when the snapshot was taken.
+// Assumes server is stopped.
+func RestoreSnapshot(snapshot Backup, progress Progress) error {
+	if !Exists(snapshot.Path) {
+		return errors.New("snapshot file does not exist")
+	}
+	return RestoreBackup(snapshot, progress)
+}
+
+// DeleteSnapshot removes a snapshot's file.
+func DeleteSnapshot(snapshot Backup) error {
+	if err := os.Remove(snapshot.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package files
+
+// files/sources.go is for backups made up of several source paths, each filtered by include/exclude globs.
+//
+// Each source is stored in the archive under a folder named after it, and a manifest describing the sources is
+// written as the first entry so restores can put each part back where it came from. Backups without a manifest
+// were made before sources were configurable and contain only the game save, relative to SaveDirPath.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const manifestName = ".tsm-manifest.json"
+
+var errStopWalk = errors.New("stop walk")
+
+// BackupManifest describes the sources a backup was made from.
+type BackupManifest struct {
+	Version int            `json:"version"`
+	Created time.Time      `json:"created"`
+	Sources []BackupSource `json:"sources"`
+	Bytes   int64          `json:"bytes,omitempty"` // total size of the files in the backup, uncompressed
+	Files   int64          `json:"files,omitempty"`
+}
+
+// GetBackupSources returns the configured backup sources, or just the game save if there aren't any.
+func GetBackupSources() []BackupSource {
+	if len(Config.BackupSources) > 0 {
+		return Config.BackupSources
+	}
+	return []BackupSource{{Name: filepath.Base(SavePath), Path: SavePath}}
+}
+
+// validateBackupSources makes sure every source has a unique usable name and exists.
+func validateBackupSources(sources []BackupSource) error {
+	names := map[string]bool{}
+	for _, source := range sources {
+		if source.Name == "" || source.Name == "." || source.Name == ".." || source.Name == manifestName || strings.ContainsAny(source.Name, `/\`) {
+			return fmt.Errorf("invalid backup source name: %q", source.Name)
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate backup source name: %s", source.Name)
+		}
+		names[source.Name] = true
+		if !Exists(source.Path) {
+			return fmt.Errorf("backup source %s path does not exist: %s", source.Name, source.Path)
+		}
+		for _, pattern := range append(append([]string{}, source.Include...), source.Exclude...) {
+			if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+				return fmt.Errorf("invalid glob in backup source %s: %s", source.Name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// ==== Glob matching =========================================================
+
+// matchGlob matches a slash separated relative path against a glob. "**" matches any number of directories,
+// and patterns without a slash match the base name at any depth, e.g. "*.log" matches "logs/a.log".
+func matchGlob(pattern, name string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchesAny returns true if name, or any directory above it, matches one of the patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		for prefix := name; prefix != "."; prefix = path.Dir(prefix) {
+			if matchGlob(pattern, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isExcluded returns true if the path (relative to the source, slash separated) is filtered out of the source.
+// Directories are never excluded by the include list, so the walk can reach included files inside them.
+func (source BackupSource) isExcluded(rel string, isDir bool) bool {
+	if rel == "." {
+		return false
+	}
+	if matchesAny(source.Exclude, rel) {
+		return true
+	}
+	return !isDir && len(source.Include) > 0 && !matchesAny(source.Include, rel)
+}
+
+func (source BackupSource) isFiltered() bool {
+	return len(source.Include) > 0 || len(source.Exclude) > 0
+}
+
+// walkSource calls fn for every path in the source that passes its filters, rel being relative to source.Path.
+func walkSource(source BackupSource, fn func(path, rel string, d os.DirEntry) error) error {
+	return filepath.WalkDir(source.Path, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source.Path, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if source.isExcluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(path, rel, d)
+	})
+}
+
+// sourceTotals returns the total size and number of the regular files in the source that pass its filters.
+func sourceTotals(source BackupSource) (int64, int64, error) {
+	var bytes, files int64
+	err := walkSource(source, func(path, rel string, d os.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		bytes += info.Size()
+		files++
+		return nil
+	})
+	return bytes, files, err
+}
+
+// sourceEntryName is the archive entry name of a path inside a source.
+func sourceEntryName(source BackupSource, rel string) string {
+	if rel == "." {
+		return source.Name
+	}
+	return source.Name + "/" + rel
+}
+
+// ==== Archiving =============================================================
+
+// addSourceToArchive adds every file in the source that passes its filters to the archive, under the source's name.
+// Symlinks are stored as links and never followed.
+func addSourceToArchive(aw ArchiveWriter, source BackupSource, progress Progress) error {
+	return walkSource(source, func(path, rel string, d os.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry, err := entryFromFileInfo(sourceEntryName(source, rel), path, info)
+		if err != nil {
+			return err
+		}
+		if err := writeFileEntry(aw, entry, path); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			progress.Advance(info.Size(), 1)
+		}
+		return nil
+	})
+}
+
+// archiveSources adds the manifest then every source in it to the archive.
+func archiveSources(aw ArchiveWriter, manifest BackupManifest, progress Progress) error {
+	progress = orNop(progress)
+	if err := writeManifest(aw, manifest); err != nil {
+		return err
+	}
+	for _, source := range manifest.Sources {
+		progress.Logf("Archiving %s", source.Path)
+		if err := addSourceToArchive(aw, source, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeManifest adds the manifest as an entry, it should be the first entry in the archive.
+func writeManifest(aw ArchiveWriter, manifest BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	entry := ArchiveEntry{
+		Path:    manifestName,
+		Size:    int64(len(data)),
+		ModTime: manifest.Created,
+		Mode:    0644,
+	}
+	return aw.WriteEntry(entry, strings.NewReader(string(data)))
+}
+
+// readManifest returns the manifest of the backup, or nil if it was made before manifests existed.
+func readManifest(backup Backup) (*BackupManifest, error) {
+	var manifest *BackupManifest
+	err := walkBackup(backup, func(entry ArchiveEntry, r io.Reader) error {
+		if entry.Path == manifestName && r != nil {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(r).Decode(manifest); err != nil {
+				return err
+			}
+		}
+		return errStopWalk // the manifest is always first
+	})
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ==== Restoring =============================================================
+
+// entryResolver maps archive entry names to where they live on disk, "" means the entry isn't restored.
+type entryResolver func(name string) (string, error)
+
+// resolverFor returns the entry resolver for a backup with the given manifest (nil for older backups).
+func resolverFor(manifest *BackupManifest) entryResolver {
+	if manifest == nil {
+		return func(name string) (string, error) {
+			return safeJoin(SaveDirPath, name)
+		}
+	}
+	return func(name string) (string, error) {
+		if name == manifestName {
+			return "", nil
+		}
+		sourceName, rel, _ := strings.Cut(name, "/")
+		for _, source := range manifest.Sources {
+			if source.Name == sourceName {
+				if rel == "" {
+					return source.Path, nil
+				}
+				return safeJoin(source.Path, rel)
+			}
+		}
+		return "", fmt.Errorf("archive entry %s doesn't belong to any source in the manifest", name)
+	}
+}
+
+// clearSource removes the live copy of everything under rel ("." for all of it) in a source before it's restored.
+// Filtered sources only have the files their filters would have backed up removed, so excluded files (caches, etc.)
+// are left alone.
+func clearSource(source BackupSource, rel string) error {
+	livePath, err := safeJoin(source.Path, rel)
+	if err != nil {
+		return err
+	}
+	if !source.isFiltered() {
+		return os.RemoveAll(livePath)
+	}
+	if !Exists(livePath) {
+		return nil
+	}
+	return walkSource(source, func(path, fileRel string, d os.DirEntry) error {
+		if d.IsDir() || (rel != "." && !isInSubtree(fileRel, rel)) {
+			return nil
+		}
+		return os.Remove(path)
+	})
+}
+
+// clearEntry removes the live copy of an archive entry (and everything under it) before it's restored.
+func clearEntry(manifest *BackupManifest, entryPath string) error {
+	if manifest == nil {
+		livePath, err := safeJoin(SaveDirPath, entryPath)
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(livePath)
+	}
+	sourceName, rel, _ := strings.Cut(entryPath, "/")
+	for _, source := range manifest.Sources {
+		if source.Name == sourceName {
+			if rel == "" {
+				rel = "."
+			}
+			return clearSource(source, rel)
+		}
+	}
+	return ErrEntryNotFound
+}
@@ -0,0 +1,135 @@
+package files
+
+// files/tar.go is the tar.gz and tar.zst implementation of Archiver.
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type tarArchiver struct {
+	compression string // FormatTarGz or FormatTarZst
+}
+
+type tarArchiveWriter struct {
+	archive    *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (ta tarArchiver) Extension() string { return ta.compression }
+
+func (ta tarArchiver) NewWriter(w io.Writer) (ArchiveWriter, error) {
+	var compressor io.WriteCloser
+	var err error
+	switch ta.compression {
+	case FormatTarGz:
+		compressor = gzip.NewWriter(w)
+	case FormatTarZst:
+		compressor, err = zstd.NewWriter(w)
+	default:
+		err = errors.New("unknown tar compression: " + ta.compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tarArchiveWriter{archive: tar.NewWriter(compressor), compressor: compressor}, nil
+}
+
+func (tw *tarArchiveWriter) WriteEntry(entry ArchiveEntry, r io.Reader) error {
+	header := &tar.Header{
+		Name:    entry.Path,
+		Mode:    int64(entry.Mode.Perm()),
+		ModTime: entry.ModTime,
+		Format:  tar.FormatPAX, // long names and sub-second mtimes
+	}
+
+	switch {
+	case entry.IsDir:
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+	case entry.Mode&os.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = entry.LinkTarget
+	default:
+		header.Typeflag = tar.TypeReg
+		header.Size = entry.Size
+	}
+
+	if err := tw.archive.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeReg {
+		_, err := io.CopyN(tw.archive, r, entry.Size)
+		return err
+	}
+	return nil
+}
+
+func (tw *tarArchiveWriter) Close() error {
+	if err := tw.archive.Close(); err != nil {
+		tw.compressor.Close()
+		return err
+	}
+	return tw.compressor.Close()
+}
+
+func (ta tarArchiver) Walk(file io.Reader, fn WalkFunc) error {
+	var decompressed io.Reader
+	switch ta.compression {
+	case FormatTarGz:
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		decompressed = gzipReader
+	case FormatTarZst:
+		zstdReader, err := zstd.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zstdReader.Close()
+		decompressed = zstdReader
+	default:
+		return errors.New("unknown tar compression: " + ta.compression)
+	}
+
+	reader := tar.NewReader(decompressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// only directories, regular files and symlinks are ever archived
+		name := cleanEntryName(header.Name)
+		if name == "" || (header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink) {
+			continue
+		}
+		info := header.FileInfo()
+		entry := ArchiveEntry{
+			Path:       name,
+			ModTime:    header.ModTime,
+			Mode:       info.Mode(),
+			IsDir:      info.IsDir(),
+			LinkTarget: header.Linkname,
+		}
+
+		var contents io.Reader
+		if header.Typeflag == tar.TypeReg {
+			entry.Size = header.Size
+			contents = reader
+		}
+		if err := fn(entry, contents); err != nil {
+			return err
+		}
+	}
+}
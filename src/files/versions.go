@@ -0,0 +1,57 @@
+package files
+
+// files/versions.go is for the history of installed game server builds.
+
+// GetCurrentGameVersion returns the most recently recorded build, or an empty GameVersion if there isn't one.
+func GetCurrentGameVersion() (GameVersion, error) {
+	var version GameVersion
+	result := DB.Order("id desc").Limit(1).Find(&version)
+	return version, result.Error
+}
+
+// GetGameVersions returns up to limit recorded builds, newest first.
+func GetGameVersions(limit int) ([]GameVersion, error) {
+	versions := []GameVersion{}
+	result := DB.Order("id desc").Limit(limit).Find(&versions)
+	return versions, result.Error
+}
+
+// MarkGameVersionRolledBack flags the latest record of buildID as rolled back.
+func MarkGameVersionRolledBack(buildID string) error {
+	var version GameVersion
+	if err := DB.Where("build_id = ?", buildID).Order("id desc").Limit(1).Find(&version).Error; err != nil {
+		return err
+	}
+	if version.ID == 0 {
+		return nil
+	}
+	return DB.Model(&version).Update("rolled_back", true).Error
+}
+
+// GameVersionRolledBack returns true if buildID was rolled back the last time it was installed. Installing it again
+// and staying on it records it afresh, which clears this.
+func GameVersionRolledBack(buildID string) (bool, error) {
+	var version GameVersion
+	result := DB.Where("build_id = ?", buildID).Order("id desc").Limit(1).Find(&version)
+	return version.RolledBack, result.Error
+}
+
+// RecordGameVersion records buildID as the installed build if it's different to the last one recorded.
+// Returns true if it was recorded.
+func RecordGameVersion(buildID, provider string) (bool, error) {
+	if buildID == "" {
+		return false, nil
+	}
+	current, err := GetCurrentGameVersion()
+	if err != nil {
+		return false, err
+	}
+	if current.BuildID == buildID {
+		return false, nil
+	}
+	version := GameVersion{BuildID: buildID, Previous: current.BuildID, Provider: provider}
+	if err := DB.Create(&version).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
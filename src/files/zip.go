@@ -1,196 +1,132 @@
 package files
 
+// files/zip.go is the zip implementation of Archiver.
+
 import (
 	"archive/zip"
-	"errors"
 	"io"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
-func ZipDir(source string, dest string) error {
-	// Verify that the source directory exists
-	if !DirExists(source) {
-		return errors.New("source directory does not exist or is not a directory")
-	}
+type zipArchiver struct{}
 
-	// Create the destination zip file
-	zipfile, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer zipfile.Close()
+type zipArchiveWriter struct {
+	archive *zip.Writer
+}
 
-	// Create a zip writer
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
+func (zipArchiver) Extension() string { return FormatZip }
 
-	baseDir := filepath.Base(source)
+func (zipArchiver) NewWriter(w io.Writer) (ArchiveWriter, error) {
+	return &zipArchiveWriter{archive: zip.NewWriter(w)}, nil
+}
 
-	// Walk through the source directory and add all files to the zip
-	err = filepath.WalkDir(source, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+func (zw *zipArchiveWriter) WriteEntry(entry ArchiveEntry, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     entry.Path,
+		Modified: entry.ModTime,
+	}
+	header.SetMode(entry.Mode)
 
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
+	if entry.IsDir {
+		header.Name += "/"
+		_, err := zw.archive.CreateHeader(header)
+		return err
+	}
 
-		header, err := zip.FileInfoHeader(info)
+	// symlinks are stored uncompressed with the link target as their contents, same as Info-ZIP
+	if entry.Mode&os.ModeSymlink != 0 {
+		header.Method = zip.Store
+		writer, err := zw.archive.CreateHeader(header)
 		if err != nil {
 			return err
 		}
+		_, err = io.WriteString(writer, entry.LinkTarget)
+		return err
+	}
 
-		header.Name = baseDir + "/" + strings.TrimPrefix(path, source)
+	header.Method = zip.Deflate
+	writer, err := zw.archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, r)
+	return err
+}
 
-		if d.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
-		}
+func (zw *zipArchiveWriter) Close() error {
+	return zw.archive.Close()
+}
 
-		writer, err := archive.CreateHeader(header)
+func (zipArchiver) Walk(r io.Reader, fn WalkFunc) error {
+	// zip needs random access, spool anything that isn't a plain file (e.g. decrypted backups) to a temp file first
+	file, ok := r.(*os.File)
+	if !ok {
+		spool, err := os.CreateTemp(BackupsPath, ".spool-*.zip")
 		if err != nil {
 			return err
 		}
-
-		if !d.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			_, err = io.Copy(writer, file)
-			file.Close()
-			if err != nil {
-				return err
-			}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if _, err := io.Copy(spool, r); err != nil {
+			return err
 		}
-
-		return nil
-	})
-
-	return err
-}
-
-func UnZipDir(source, dest string) error {
-	reader, err := zip.OpenReader(source)
+		file = spool
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(file, info.Size())
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
 
 	for _, file := range reader.File {
-		path := filepath.Join(dest, file.Name)
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, os.ModePerm)
+		// older backups have doubled slashes and an empty root entry, e.g. "slot0//" and "slot0//a.dat"
+		name := cleanEntryName(file.Name)
+		if name == "" {
 			continue
 		}
-
-		if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
-			return err
+		mode := file.Mode()
+		entry := ArchiveEntry{
+			Path:    name,
+			ModTime: file.Modified,
+			Mode:    mode,
+			IsDir:   mode.IsDir() || strings.HasSuffix(file.Name, "/"),
 		}
 
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return err
+		if entry.IsDir {
+			if err := fn(entry, nil); err != nil {
+				return err
+			}
+			continue
 		}
 
 		fileReader, err := file.Open()
 		if err != nil {
-			outFile.Close()
 			return err
 		}
-
-		if _, err = io.Copy(outFile, fileReader); err != nil {
-			outFile.Close()
+		if mode&os.ModeSymlink != 0 {
+			target, err := io.ReadAll(fileReader)
 			fileReader.Close()
-			return err
+			if err != nil {
+				return err
+			}
+			entry.LinkTarget = string(target)
+			err = fn(entry, nil)
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		outFile.Close()
+		entry.Size = int64(file.UncompressedSize64)
+		err = fn(entry, fileReader)
 		fileReader.Close()
+		if err != nil {
+			return err
+		}
 	}
-
-	return nil
-}
-
-func ZipFile(source, dest string) error {
-	// Verify that the source file exists
-	if !FileExists(source) {
-		return errors.New("source file does not exist")
-	}
-
-	// Create the destination zip file
-	zipfile, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer zipfile.Close()
-
-	// Create a zip writer
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
-
-	// Open the source file
-	file, err := os.Open(source)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Create a new file in the zip archive
-	writer, err := archive.Create(filepath.Base(source))
-	if err != nil {
-		return err
-	}
-
-	// Copy the source file to the zip archive
-	_, err = io.Copy(writer, file)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func UnZipFile(source, dest string) error {
-	reader, err := zip.OpenReader(source)
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
-
-	if len(reader.File) != 1 {
-		return errors.New("zip file contains more than one file")
-	}
-
-	file := reader.File[0]
-	if file.FileInfo().IsDir() {
-		return errors.New("zip file contains a directory")
-	}
-
-	// Create the destination file
-	outFile, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
-
-	// Open the file in the zip archive
-	fileReader, err := file.Open()
-	if err != nil {
-		return err
-	}
-	defer fileReader.Close()
-
-	// Copy the file to the destination
-	_, err = io.Copy(outFile, fileReader)
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
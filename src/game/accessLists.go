@@ -0,0 +1,35 @@
+package game
+
+// game/accessLists.go is for changing whitelists, ban lists etc. Games that keep their lists in memory get the
+// change through the console while they're running, otherwise the list's file is edited.
+
+import (
+	"fmt"
+	"strings"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// AddToAccessList adds a player to a list, returning the console's response if it went through the console.
+func AddToAccessList(list files.AccessList, name string) (string, error) {
+	return changeAccessList(list, name, list.AddCommand, files.AddToAccessList)
+}
+
+// RemoveFromAccessList removes a player from a list, returning the console's response if it went through the console.
+func RemoveFromAccessList(list files.AccessList, name string) (string, error) {
+	return changeAccessList(list, name, list.RemoveCommand, files.RemoveFromAccessList)
+}
+
+func changeAccessList(list files.AccessList, name, command string, edit func(files.AccessList, string) error) (string, error) {
+	if err := files.CheckPlayerName(name); err != nil {
+		return "", err
+	}
+	if command == "" || !Process.GetRunning() {
+		return "", edit(list, name)
+	}
+	command = strings.ReplaceAll(command, "{name}", name)
+	blog.Info(fmt.Sprintf("Changing access list %s: %s", list.Name, command))
+	return Process.Console(command)
+}
@@ -1,7 +1,9 @@
 package game
 
 import (
+	"fmt"
 	"time"
+
 	"tsm/src/files"
 
 	"github.com/Data-Corruption/blog"
@@ -13,7 +15,11 @@ var (
 )
 
 func InitAutoBackup() {
-	go autoBackupGoroutine()
+	policy, err := newPlayerPolicy("auto_backup_player_policy", files.Config.AutoBackupPlayerPolicy)
+	if err != nil {
+		panic(err)
+	}
+	go autoBackupGoroutine(policy)
 }
 
 func StopAutoBackup() {
@@ -21,29 +27,13 @@ func StopAutoBackup() {
 	<-AutoBackupDoneChan
 }
 
-func backup() error {
-	Process.Mutex.Lock()
-	defer Process.Mutex.Unlock()
-
-	if err := Process.Stop(); err != nil {
-		return err
-	}
-	if err := files.CreateBackup("Automatic"); err != nil {
-		return err
-	}
-	if err := Process.Start(); err != nil {
-		return err
-	}
-	return nil
-}
-
 func timeUntilMidnight() time.Duration {
 	now := time.Now()
 	nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
 	return nextMidnight.Sub(now)
 }
 
-func autoBackupGoroutine() {
+func autoBackupGoroutine(policy *playerPolicy) {
 	// Calculate initial delay until next midnight
 	delay := timeUntilMidnight()
 	ticker := time.NewTicker(delay)
@@ -51,11 +41,11 @@ func autoBackupGoroutine() {
 	for {
 		select {
 		case <-ticker.C:
-			if err := backup(); err != nil {
-				blog.Error(err.Error())
-				panic(err)
+			if stopped := autoBackup(policy); stopped {
+				ticker.Stop()
+				AutoBackupDoneChan <- struct{}{}
+				return
 			}
-			blog.Info("Performed automatic backup")
 			ticker.Reset(timeUntilMidnight())
 		case <-AutoBackupStopChan:
 			ticker.Stop()
@@ -64,3 +54,54 @@ func autoBackupGoroutine() {
 		}
 	}
 }
+
+// autoBackup takes the nightly backup once maintenance mode is off and the player policy lets it, checking every
+// minute while it's waiting. Returns true if it was stopped while waiting.
+func autoBackup(policy *playerPolicy) bool {
+	if MaintenanceActive() {
+		blog.Info("Automatic backup is waiting for maintenance mode to end")
+	}
+	for {
+		if stopped := waitForMaintenance(AutoBackupStopChan); stopped {
+			return true
+		}
+		result, count := policy.check(time.Now())
+		switch result {
+		case policySkip:
+			blog.Info(fmt.Sprintf("Skipped automatic backup, %s", playersOnline(count)))
+			return false
+		case policyWait:
+			blog.Debug(fmt.Sprintf("Waiting for the server to empty before the automatic backup, %s", playersOnline(count)))
+			select {
+			case <-time.After(time.Minute):
+				continue
+			case <-AutoBackupStopChan:
+				return true
+			}
+		}
+
+		if count != 0 {
+			// hot backups don't kick anyone, but the server might lag
+			event := "restart for a backup"
+			if HotBackupsEnabled() {
+				event = "be backed up"
+			}
+			if warnCountdown(policy.warnings(nil), event, blog.Info, AutoBackupStopChan) {
+				return true
+			}
+		}
+		// maintenance mode might have been turned on during the countdown
+		if MaintenanceActive() {
+			blog.Info("Automatic backup is waiting for maintenance mode to end")
+			continue
+		}
+		// a failed backup is recorded on its job, there's no reason to take TSM down with it
+		if err := RunJob("backup", BackupJob("Automatic")); err != nil {
+			blog.Error(fmt.Sprintf("Automatic backup failed: %s", err.Error()))
+			Notify("auto_backup_failed", fmt.Sprintf("Automatic backup failed: %s", err.Error()), "")
+			return false
+		}
+		blog.Info("Performed automatic backup")
+		return false
+	}
+}
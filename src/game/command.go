@@ -0,0 +1,119 @@
+package game
+
+// game/command.go is for running external commands like the update command, with a timeout and their output kept.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"tsm/src/files"
+)
+
+// splitCommand splits a command line into arguments the way a POSIX shell would, without expanding anything.
+// Single quotes keep everything literally, double quotes allow \" \\ \$ and \` escapes, and a backslash outside
+// quotes escapes the next character.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			inArg = true
+			end := indexRune(runes, i+1, func(r rune) bool { return r == '\'' })
+			if end == -1 {
+				return nil, errors.New("unterminated single quote in command")
+			}
+			current.WriteString(string(runes[i+1 : end]))
+			i = end
+		case c == '"':
+			inArg = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]) {
+					i++
+				}
+				current.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated double quote in command")
+			}
+		case c == '\\':
+			inArg = true
+			if i+1 >= len(runes) {
+				return nil, errors.New("trailing backslash in command")
+			}
+			i++
+			current.WriteRune(runes[i])
+		default:
+			inArg = true
+			current.WriteRune(c)
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+func indexRune(runes []rune, from int, match func(r rune) bool) int {
+	for i := from; i < len(runes); i++ {
+		if match(runes[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// runCommand runs argv with stdout and stderr written to output, killing it (and anything it started) if it takes
+// longer than timeout. A timeout of 0 means no limit.
+func runCommand(output io.Writer, timeout time.Duration, argv []string) error {
+	if len(argv) == 0 || argv[0] == "" {
+		return errors.New("command is empty")
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// own process group so the whole tree can be killed, update scripts tend to start other programs
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// don't wait forever on pipes held open by leftover children
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", argv[0], timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", argv[0], err)
+	}
+	return nil
+}
+
+// updateTimeout is how long update commands can run for.
+func updateTimeout() time.Duration {
+	return time.Duration(files.Config.UpdateTimeoutMins) * time.Minute
+}
@@ -0,0 +1,123 @@
+package game
+
+// game/console.go is for sending commands to the game server's console, over RCON when it's configured and through
+// the server's stdin when it isn't.
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"tsm/src/files"
+	"tsm/src/rcon"
+)
+
+const rconTimeout = 10 * time.Second
+
+var (
+	rconClient    *rcon.Client
+	rconClientRun <-chan struct{} // the run of the server the connection was made to
+	rconMutex     sync.Mutex
+)
+
+// RconEnabled returns true if console commands go over RCON.
+func RconEnabled() bool {
+	return files.Config.RconAddress != ""
+}
+
+// HotBackupsEnabled returns true if save backups are taken over RCON without stopping the server.
+func HotBackupsEnabled() bool {
+	return RconEnabled() && len(files.Config.RconHotBackupBefore) > 0
+}
+
+// checkConsoleConfig makes sure the console settings make sense, called on startup.
+func checkConsoleConfig() error {
+	if files.Config.RconPlayerCountCommand != "" {
+		if _, err := playerCountRegex(); err != nil {
+			return err
+		}
+	}
+	if HotBackupsEnabled() && len(files.Config.RconHotBackupAfter) == 0 {
+		return errors.New("rcon_hot_backup_after must be set with rcon_hot_backup_before, or saving would stay off")
+	}
+	return nil
+}
+
+// Console runs a command in the server's console. Over RCON the server's response is returned, through stdin it's
+// always empty since the response goes to the server's output instead.
+func (pm *ProcessManager) Console(command string) (string, error) {
+	if RconEnabled() {
+		return rconExecute(command)
+	}
+	return "", pm.SendCommand(command)
+}
+
+// rconExecute runs a command over the shared RCON connection. It reconnects if the server has restarted since the
+// connection was made, but never retries a command since it might have run already.
+func rconExecute(command string) (string, error) {
+	rconMutex.Lock()
+	defer rconMutex.Unlock()
+
+	var run <-chan struct{}
+	if Process != nil {
+		run = Process.Exited()
+	}
+	if rconClient != nil && rconClientRun != run {
+		rconClient.Close()
+		rconClient = nil
+	}
+	if rconClient == nil {
+		client, err := rcon.Dial(files.Config.RconAddress, files.Config.RconPassword, rconTimeout)
+		if err != nil {
+			return "", fmt.Errorf("couldn't connect to rcon: %w", err)
+		}
+		rconClient, rconClientRun = client, run
+	}
+	response, err := rconClient.Execute(command)
+	if err != nil {
+		rconClient.Close()
+		rconClient = nil
+		return "", err
+	}
+	return response, nil
+}
+
+// playerCountRegex compiles rcon_player_count_regex, it needs a count group or at least one group.
+func playerCountRegex() (*regexp.Regexp, error) {
+	re, err := regexp.Compile(files.Config.RconPlayerCountRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rcon_player_count_regex: %w", err)
+	}
+	if re.NumSubexp() == 0 {
+		return nil, errors.New("rcon_player_count_regex needs a (?P<count>...) group")
+	}
+	return re, nil
+}
+
+// rconPlayerCount runs rcon_player_count_command and finds the count in the response.
+func rconPlayerCount() (int, error) {
+	re, err := playerCountRegex()
+	if err != nil {
+		return 0, err
+	}
+	response, err := rconExecute(files.Config.RconPlayerCountCommand)
+	if err != nil {
+		return 0, err
+	}
+	match := re.FindStringSubmatch(response)
+	if match == nil {
+		return 0, fmt.Errorf("rcon_player_count_regex didn't match %q", response)
+	}
+	group := 1
+	if index := re.SubexpIndex("count"); index != -1 {
+		group = index
+	}
+	count, err := strconv.Atoi(match[group])
+	if err != nil {
+		return 0, fmt.Errorf("rcon_player_count_regex matched %q, not a number", match[group])
+	}
+	return count, nil
+}
@@ -1,9 +1,12 @@
 package game
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"os/exec"
-	"strings"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -22,13 +25,29 @@ func InitGameServer() {
 		panic("game exe path not set")
 	}
 
-	// check if the exe path is valid
-	if !files.FileExists(files.Config.GameExePath) {
+	// check if the exe path is valid, a bare name like "java" is looked up on the PATH
+	exePath := files.Config.GameExePath
+	var err error
+	if files.FileExists(exePath) {
+		// absolute since the server runs from install_dir
+		exePath, err = filepath.Abs(exePath)
+	} else if filepath.Base(exePath) == exePath {
+		exePath, err = exec.LookPath(exePath)
+	} else {
 		panic("invalid game exe path")
 	}
+	if err != nil {
+		panic("invalid game exe path: " + err.Error())
+	}
+	if _, err := stopSignal(); err != nil {
+		panic(err)
+	}
+	if err := checkConsoleConfig(); err != nil {
+		panic(err)
+	}
 
 	// init the process manager
-	Process = NewProcessManager(files.Config.GameExePath)
+	Process = NewProcessManager(exePath, files.Config.GameArgs)
 
 	// start the server
 	if err := Process.Start(); err != nil {
@@ -36,30 +55,6 @@ func InitGameServer() {
 	}
 }
 
-// Updates the server (runs the UpdateCommand from the config) assumes server is stopped and Mutex is locked
-func Update() error {
-	if files.Config.UpdateCommand == "" {
-		return errors.New("update command not set")
-	}
-
-	// Tokenize the update command
-	args := strings.Fields(files.Config.UpdateCommand)
-	if len(args) == 0 {
-		return errors.New("update command is empty after tokenizing")
-	}
-
-	// create and run the update command
-	cmd := exec.Command(args[0], args[1:]...)
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-
-	// sleep for 1 second (probably not necessary)
-	time.Sleep(1 * time.Second)
-
-	return nil
-}
-
 type ProcessManager struct {
 	// used by routes and auto backup
 	Mutex        sync.Mutex
@@ -67,20 +62,35 @@ type ProcessManager struct {
 	status       string // Verbose status of the process
 	runningMutex sync.Mutex
 	statusMutex  sync.Mutex
-	command      string    // command to run
-	cmd          *exec.Cmd // command object
-	// channels for communication with the run goroutine and it's child
-	stopChan chan struct{}
-	doneChan chan error
+	command      string         // command to run
+	args         []string       // and its args
+	cmd          *exec.Cmd      // command object
+	stdin        io.WriteCloser // the server's console
+	stdinMutex   sync.Mutex
+	// channels for communication with the run goroutine and it's child, remade on every start under chanMutex
+	chanMutex sync.Mutex
+	stopChan  chan struct{}
+	doneChan  chan error
+	exitChan  chan struct{} // closed when the process exits, whether it was stopped or not
+	// funcs called with each line the process prints, and when it exits
+	outputMutex     sync.Mutex
+	outputListeners map[int]func(line string)
+	exitListeners   map[int]func()
+	nextListenerID  int
 }
 
-func NewProcessManager(command string) *ProcessManager {
+func NewProcessManager(command string, args []string) *ProcessManager {
 	return &ProcessManager{
 		running:  false,
 		status:   "Hasn't started yet",
 		command:  command,
+		args:     args,
 		stopChan: make(chan struct{}),
-		doneChan: make(chan error),
+		doneChan: make(chan error, 1),
+		exitChan: make(chan struct{}),
+
+		outputListeners: map[int]func(line string){},
+		exitListeners:   map[int]func(){},
 	}
 }
 
@@ -118,11 +128,29 @@ func (pm *ProcessManager) Start() error {
 		return errors.New("process already running")
 	}
 
-	pm.cmd = exec.Command(pm.command)
+	pm.cmd = exec.Command(pm.command, pm.args...)
 	pm.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// servers like minecraft expect to run from their own directory
+	pm.cmd.Dir = files.GetInstallDir()
+	stdin, err := pm.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	pm.stdinMutex.Lock()
+	pm.stdin = stdin
+	pm.stdinMutex.Unlock()
+	output := &lineWriter{onLine: pm.handleOutputLine}
+	pm.cmd.Stdout = output
+	pm.cmd.Stderr = output
+	// don't hang on pipes held open by anything the server left running
+	pm.cmd.WaitDelay = 5 * time.Second
+	stopChan, doneChan, exitChan := make(chan struct{}), make(chan error, 1), make(chan struct{})
+	pm.chanMutex.Lock()
+	pm.stopChan, pm.doneChan, pm.exitChan = stopChan, doneChan, exitChan
+	pm.chanMutex.Unlock()
 	blog.Debug("Created command")
 
-	go pm.runProcess()
+	go pm.runProcess(pm.cmd, stopChan, doneChan, exitChan)
 	blog.Debug("Started run goroutine")
 
 	return nil
@@ -136,49 +164,192 @@ func (pm *ProcessManager) Stop() error {
 		return errors.New("process not running")
 	}
 
-	pm.stopChan <- struct{}{}
-	blog.Debug("Sent stop signal")
-	err := <-pm.doneChan
+	pm.chanMutex.Lock()
+	stopChan, doneChan, exitChan := pm.stopChan, pm.doneChan, pm.exitChan
+	pm.chanMutex.Unlock()
+	select {
+	case stopChan <- struct{}{}:
+		blog.Debug("Sent stop signal")
+	case <-exitChan:
+		blog.Debug("Process already exited")
+	}
+	err := <-doneChan
 	blog.Debug("Received done signal")
 
-	// err should now be 'signal: terminated' or nil, else return error
-	if err != nil && err.Error() != "signal: terminated" {
+	// err should now be 'signal: terminated' (or interrupt) or nil, else return error
+	if err != nil && err.Error() != "signal: terminated" && err.Error() != "signal: interrupt" {
 		return err
 	} else {
 		return nil
 	}
 }
 
-func (pm *ProcessManager) runProcess() {
-	if err := pm.cmd.Start(); err != nil {
-		pm.doneChan <- err
+// SendCommand writes a line to the server's console.
+func (pm *ProcessManager) SendCommand(command string) error {
+	if !pm.GetRunning() {
+		return errors.New("process not running")
+	}
+	pm.stdinMutex.Lock()
+	defer pm.stdinMutex.Unlock()
+	if pm.stdin == nil {
+		return errors.New("process not running")
+	}
+	_, err := io.WriteString(pm.stdin, command+"\n")
+	return err
+}
+
+// stopSignal returns the configured stop signal.
+func stopSignal() (syscall.Signal, error) {
+	switch files.Config.StopSignal {
+	case "", "SIGTERM":
+		return syscall.SIGTERM, nil
+	case "SIGINT":
+		return syscall.SIGINT, nil
+	}
+	return 0, fmt.Errorf("invalid stop_signal %q, expected SIGTERM or SIGINT", files.Config.StopSignal)
+}
+
+func stopTimeout() time.Duration {
+	if files.Config.StopTimeoutSecs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(files.Config.StopTimeoutSecs) * time.Second
+}
+
+// Exited returns a channel that's closed when the current run of the process exits.
+func (pm *ProcessManager) Exited() <-chan struct{} {
+	pm.chanMutex.Lock()
+	defer pm.chanMutex.Unlock()
+	return pm.exitChan
+}
+
+// runProcess runs one start of the server. It's given that run's command and channels rather than reading them off
+// pm, since the next Start replaces them.
+func (pm *ProcessManager) runProcess(cmd *exec.Cmd, stopChan chan struct{}, doneChan chan error, exitChan chan struct{}) {
+	if err := cmd.Start(); err != nil {
 		pm.SetRunning(false)
+		doneChan <- err
+		close(exitChan)
 		return
 	}
 
 	pm.SetRunning(true)
 
 	go func() {
-		<-pm.stopChan
+		select {
+		case <-stopChan:
+		case <-exitChan:
+			return // exited on its own
+		}
 		blog.Debug("Received stop signal from channel")
-		if pm.cmd.Process != nil {
-			blog.Debug("Sending SIGTERM to child process")
-			pgid, err := syscall.Getpgid(pm.cmd.Process.Pid)
+		// ask nicely first if there's a stop command
+		if files.Config.StopCommand != "" {
+			blog.Debug("Sending stop command to child process")
+			if _, err := pm.Console(files.Config.StopCommand); err != nil {
+				blog.Error(err.Error())
+			}
+			select {
+			case <-exitChan:
+				return
+			case <-time.After(stopTimeout()):
+				blog.Warn("Game server didn't stop after the stop command, sending a signal")
+			}
+		}
+		if cmd.Process != nil {
+			signal, _ := stopSignal()
+			blog.Debug("Sending " + signal.String() + " to child process")
+			pgid, err := syscall.Getpgid(cmd.Process.Pid)
 			if err != nil {
 				blog.Error(err.Error())
 				return
 			}
-			if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+			if err := syscall.Kill(-pgid, signal); err != nil {
 				blog.Error(err.Error())
 			}
 		}
 	}()
 
-	err := pm.cmd.Wait()
+	err := cmd.Wait()
+	pm.stdinMutex.Lock()
+	pm.stdin = nil
+	pm.stdinMutex.Unlock()
 	// add extra buffer of 3 seconds to allow for graceful shutdown
 	time.Sleep(3 * time.Second)
 	blog.Debug("Child process exited")
 	pm.SetRunning(false)
-	pm.doneChan <- err
+	pm.handleExit()
+	doneChan <- err
+	close(exitChan)
 	blog.Debug("Sent done signal")
 }
+
+// ==== Output ================================================================
+
+// OnOutput calls fn with every line the server prints from now on, until the returned func is called.
+func (pm *ProcessManager) OnOutput(fn func(line string)) (remove func()) {
+	pm.outputMutex.Lock()
+	defer pm.outputMutex.Unlock()
+	id := pm.nextListenerID
+	pm.nextListenerID++
+	pm.outputListeners[id] = fn
+	return func() {
+		pm.outputMutex.Lock()
+		defer pm.outputMutex.Unlock()
+		delete(pm.outputListeners, id)
+	}
+}
+
+// OnExit calls fn whenever the server exits from now on, whether it was stopped or not, until the returned func
+// is called.
+func (pm *ProcessManager) OnExit(fn func()) (remove func()) {
+	pm.outputMutex.Lock()
+	defer pm.outputMutex.Unlock()
+	id := pm.nextListenerID
+	pm.nextListenerID++
+	pm.exitListeners[id] = fn
+	return func() {
+		pm.outputMutex.Lock()
+		defer pm.outputMutex.Unlock()
+		delete(pm.exitListeners, id)
+	}
+}
+
+func (pm *ProcessManager) handleExit() {
+	pm.outputMutex.Lock()
+	defer pm.outputMutex.Unlock()
+	for _, fn := range pm.exitListeners {
+		fn()
+	}
+}
+
+func (pm *ProcessManager) handleOutputLine(line string) {
+	pm.outputMutex.Lock()
+	defer pm.outputMutex.Unlock()
+	for _, fn := range pm.outputListeners {
+		fn(line)
+	}
+}
+
+// lineWriter splits what's written to it into lines.
+type lineWriter struct {
+	onLine  func(line string)
+	partial []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.partial = append(lw.partial, p...)
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i == -1 {
+			break
+		}
+		lw.onLine(string(bytes.TrimRight(lw.partial[:i], "\r")))
+		lw.partial = lw.partial[i+1:]
+	}
+	// a server printing without newlines shouldn't grow this forever
+	if len(lw.partial) > 64<<10 {
+		lw.onLine(string(lw.partial))
+		lw.partial = nil
+	}
+	return len(p), nil
+}
@@ -0,0 +1,393 @@
+package game
+
+// game/jobs.go runs long operations (backups, restores, updates) in the background so requests can return
+// straight away. Job state is kept in memory while running and saved to the database as it changes.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+const (
+	jobSaveInterval   = time.Second // how often progress is saved while a job is running
+	jobLogMaxBytes    = 64 << 10    // older log lines are dropped past this
+	jobOutputMaxBytes = 4 << 20     // older command output is dropped past this
+)
+
+var (
+	activeJobs      = map[uint]*jobRunner{}
+	activeJobsMutex sync.Mutex
+	jobsWaitGroup   sync.WaitGroup
+)
+
+// JobFunc does the work of a job, reporting progress as it goes.
+type JobFunc func(progress files.Progress) error
+
+// jobRunner tracks a running job, it's the Progress passed to the job's JobFunc.
+type jobRunner struct {
+	mutex       sync.Mutex
+	job         files.Job
+	lastSave    time.Time
+	subscribers map[chan struct{}]struct{}
+}
+
+// InitJobs marks jobs interrupted by the last shutdown as failed.
+func InitJobs() {
+	count, err := files.FailInterruptedJobs()
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		blog.Warn(fmt.Sprintf("Marked %d jobs interrupted by the last shutdown as failed", count))
+	}
+}
+
+// StopJobs waits for running jobs to finish, cutting a backup or restore short would leave a mess.
+func StopJobs() {
+	jobsWaitGroup.Wait()
+}
+
+// StartJob creates a job and runs fn in the background, returning the job straight away.
+func StartJob(jobType string, fn JobFunc) (files.Job, error) {
+	runner, err := newJobRunner(jobType)
+	if err != nil {
+		return files.Job{}, err
+	}
+	job := runner.snapshot()
+	go runner.run(fn)
+	return job, nil
+}
+
+// RunJob creates a job and runs fn, returning once it's finished with the job's error.
+func RunJob(jobType string, fn JobFunc) error {
+	runner, err := newJobRunner(jobType)
+	if err != nil {
+		return err
+	}
+	return runner.run(fn)
+}
+
+// GetJob returns the current state of the job.
+func GetJob(ID uint) (files.Job, error) {
+	activeJobsMutex.Lock()
+	runner, ok := activeJobs[ID]
+	activeJobsMutex.Unlock()
+	if ok {
+		return runner.snapshot(), nil
+	}
+	return files.GetJob(ID)
+}
+
+// SubscribeJob returns a channel that's signalled whenever the job changes, and a func to unsubscribe.
+// ok is false if the job isn't running, in which case it won't change again.
+func SubscribeJob(ID uint) (<-chan struct{}, func(), bool) {
+	activeJobsMutex.Lock()
+	runner, ok := activeJobs[ID]
+	activeJobsMutex.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	ch := make(chan struct{}, 1)
+	runner.mutex.Lock()
+	runner.subscribers[ch] = struct{}{}
+	runner.mutex.Unlock()
+	return ch, func() {
+		runner.mutex.Lock()
+		delete(runner.subscribers, ch)
+		runner.mutex.Unlock()
+	}, true
+}
+
+// IsJobDone returns true if the job has finished, either way.
+func IsJobDone(job files.Job) bool {
+	return job.Status == files.JobSucceeded || job.Status == files.JobFailed
+}
+
+// ==== Runner ================================================================
+
+func newJobRunner(jobType string) (*jobRunner, error) {
+	runner := &jobRunner{
+		job:         files.Job{Type: jobType, Status: files.JobQueued},
+		subscribers: map[chan struct{}]struct{}{},
+	}
+	if err := files.DB.Create(&runner.job).Error; err != nil {
+		return nil, err
+	}
+
+	activeJobsMutex.Lock()
+	activeJobs[runner.job.ID] = runner
+	activeJobsMutex.Unlock()
+	jobsWaitGroup.Add(1)
+	return runner, nil
+}
+
+func (jr *jobRunner) run(fn JobFunc) error {
+	defer jobsWaitGroup.Done()
+
+	jr.update(true, func(job *files.Job) {
+		now := time.Now()
+		job.Status = files.JobRunning
+		job.StartedAt = &now
+	})
+	blog.Info(fmt.Sprintf("Started %s job %d", jr.job.Type, jr.job.ID))
+
+	err := fn(jr)
+
+	jr.update(true, func(job *files.Job) {
+		now := time.Now()
+		job.FinishedAt = &now
+		if err != nil {
+			job.Status = files.JobFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = files.JobSucceeded
+		}
+	})
+	if err != nil {
+		blog.Error(fmt.Sprintf("%s job %d failed: %s", jr.job.Type, jr.job.ID, err.Error()))
+	} else {
+		blog.Info(fmt.Sprintf("Finished %s job %d", jr.job.Type, jr.job.ID))
+	}
+
+	activeJobsMutex.Lock()
+	delete(activeJobs, jr.job.ID)
+	activeJobsMutex.Unlock()
+	return err
+}
+
+func (jr *jobRunner) snapshot() files.Job {
+	jr.mutex.Lock()
+	defer jr.mutex.Unlock()
+	return jr.job
+}
+
+// update applies change to the job, saves it if forced or it's been a while, and tells subscribers.
+func (jr *jobRunner) update(force bool, change func(job *files.Job)) {
+	jr.mutex.Lock()
+	defer jr.mutex.Unlock()
+
+	change(&jr.job)
+	if force || time.Since(jr.lastSave) >= jobSaveInterval {
+		if err := files.DB.Save(&jr.job).Error; err != nil {
+			blog.Error(err.Error())
+		}
+		jr.lastSave = time.Now()
+	}
+	for ch := range jr.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default: // they haven't caught up with the last one yet
+		}
+	}
+}
+
+func (jr *jobRunner) SetTotal(bytes, fileCount int64) {
+	jr.update(false, func(job *files.Job) {
+		job.TotalBytes = bytes
+		job.TotalFiles = fileCount
+	})
+}
+
+func (jr *jobRunner) Advance(bytes, fileCount int64) {
+	jr.update(false, func(job *files.Job) {
+		job.ProgressBytes += bytes
+		job.ProgressFiles += fileCount
+	})
+}
+
+func (jr *jobRunner) Logf(format string, args ...any) {
+	line := time.Now().Format("15:04:05") + " " + fmt.Sprintf(format, args...) + "\n"
+	jr.update(true, func(job *files.Job) {
+		job.Log += line
+		if len(job.Log) > jobLogMaxBytes {
+			job.Log = job.Log[len(job.Log)-jobLogMaxBytes:]
+		}
+	})
+}
+
+// Write appends command output to the job, it's saved along with progress rather than on every write.
+func (jr *jobRunner) Write(p []byte) (int, error) {
+	chunk := string(p)
+	jr.update(false, func(job *files.Job) {
+		job.Output += chunk
+		if len(job.Output) > jobOutputMaxBytes {
+			job.Output = job.Output[len(job.Output)-jobOutputMaxBytes:]
+		}
+	})
+	return len(p), nil
+}
+
+// ==== Jobs ==================================================================
+
+// lockForJob locks the game mutex, noting in the job's log if it has to wait for another operation.
+func lockForJob(progress files.Progress) {
+	if !Process.Mutex.TryLock() {
+		progress.Logf("Waiting for another operation to finish")
+		Process.Mutex.Lock()
+	}
+}
+
+// BackupJob stops the server, backs up the save, and starts it again even if the backup failed. With hot backups
+// the server is told to stop saving instead.
+func BackupJob(comment string) JobFunc {
+	create := func(progress files.Progress) error {
+		return files.CreateBackup(comment, progress)
+	}
+	if HotBackupsEnabled() {
+		return hotBackupJob(create)
+	}
+	return backupJob(create)
+}
+
+// InstallBackupJob is BackupJob for the install directory.
+func InstallBackupJob(comment string) JobFunc {
+	return backupJob(func(progress files.Progress) error {
+		return files.CreateInstallBackup(comment, progress)
+	})
+}
+
+func backupJob(create func(progress files.Progress) error) JobFunc {
+	return func(progress files.Progress) error {
+		lockForJob(progress)
+		defer Process.Mutex.Unlock()
+
+		progress.Logf("Stopping game server")
+		if err := Process.Stop(); err != nil {
+			return err
+		}
+		backupErr := create(progress)
+		if backupErr != nil {
+			progress.Logf("Backup failed: %s", backupErr.Error())
+		}
+		progress.Logf("Starting game server")
+		if err := Process.Start(); err != nil {
+			return err
+		}
+		return backupErr
+	}
+}
+
+// hotBackupJob backs up while the server keeps running, wrapped in rcon_hot_backup_before and after.
+func hotBackupJob(create func(progress files.Progress) error) JobFunc {
+	return func(progress files.Progress) error {
+		lockForJob(progress)
+		defer Process.Mutex.Unlock()
+
+		if !Process.GetRunning() {
+			return create(progress)
+		}
+		runAll := func(commands []string) error {
+			for _, command := range commands {
+				progress.Logf("Running %s", command)
+				response, err := rconExecute(command)
+				if err != nil {
+					return fmt.Errorf("%s failed: %w", command, err)
+				}
+				if response != "" {
+					progress.Logf("%s", response)
+				}
+			}
+			return nil
+		}
+
+		backupErr := runAll(files.Config.RconHotBackupBefore)
+		if backupErr == nil {
+			backupErr = create(progress)
+		}
+		if backupErr != nil {
+			progress.Logf("Backup failed: %s", backupErr.Error())
+		}
+		// always turn saving back on
+		if err := runAll(files.Config.RconHotBackupAfter); err != nil {
+			return err
+		}
+		return backupErr
+	}
+}
+
+// RestoreJob stops the server, restores the backup (from a replication target if one is given), and starts it again
+// even if the restore failed, since most failures (disk space, a bad checksum etc.) happen before anything's touched.
+func RestoreJob(backup files.Backup, target string) JobFunc {
+	return func(progress files.Progress) error {
+		lockForJob(progress)
+		defer Process.Mutex.Unlock()
+
+		progress.Logf("Stopping game server")
+		if err := Process.Stop(); err != nil {
+			return err
+		}
+		var restoreErr error
+		if target != "" {
+			restoreErr = files.RestoreBackupFromRemote(backup, target, progress)
+		} else {
+			restoreErr = files.RestoreBackup(backup, progress)
+		}
+		if restoreErr != nil {
+			progress.Logf("Restore failed: %s", restoreErr.Error())
+		}
+		progress.Logf("Starting game server")
+		return errors.Join(restoreErr, Process.Start())
+	}
+}
+
+// RestoreEntryJob stops the server, restores a single entry (and everything under it) from the backup into the live
+// save, and starts it again even if the restore failed.
+func RestoreEntryJob(backup files.Backup, entryPath string) JobFunc {
+	return func(progress files.Progress) error {
+		lockForJob(progress)
+		defer Process.Mutex.Unlock()
+
+		progress.Logf("Stopping game server")
+		if err := Process.Stop(); err != nil {
+			return err
+		}
+		progress.Logf("Restoring %s", entryPath)
+		restoreErr := files.RestoreBackupEntry(backup, entryPath)
+		if restoreErr != nil {
+			progress.Logf("Restore failed: %s", restoreErr.Error())
+		}
+		progress.Logf("Starting game server")
+		return errors.Join(restoreErr, Process.Start())
+	}
+}
+
+// UpdateJob stops the server, updates it, and starts it again even if the update failed. When rollback is enabled
+// the update is undone if it fails or leaves the server unhealthy.
+func UpdateJob() JobFunc {
+	return func(progress files.Progress) error {
+		lockForJob(progress)
+		defer Process.Mutex.Unlock()
+
+		// updates don't say how big they'll be, so just make sure the minimum is free
+		if err := files.CheckDiskSpace(files.GetInstallDir(), 0); err != nil {
+			return err
+		}
+
+		progress.Logf("Stopping game server")
+		if err := Process.Stop(); err != nil {
+			return err
+		}
+		if RollbackEnabled() {
+			return updateWithRollback(progress)
+		}
+		progress.Logf("Updating game server")
+		updateErr := Update(progress)
+		if updateErr != nil {
+			progress.Logf("Update failed: %s", updateErr.Error())
+		} else {
+			releaseUpdateHold()
+		}
+		progress.Logf("Starting game server")
+		if err := Process.Start(); err != nil {
+			return err
+		}
+		return updateErr
+	}
+}
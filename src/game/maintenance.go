@@ -0,0 +1,107 @@
+package game
+
+// game/maintenance.go is for maintenance mode, which tells players the server's down on purpose (through the
+// configured console commands) and pauses scheduled backups and updates until it's turned off. Backups, restores and
+// updates started from the dashboard still run, since they're usually why it's on.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+var (
+	maintenanceMode  files.MaintenanceMode
+	maintenanceMutex sync.Mutex
+)
+
+// InitMaintenance loads maintenance mode, it stays on across restarts until it's turned off.
+func InitMaintenance() {
+	mode, err := files.LoadMaintenanceMode()
+	if err != nil {
+		panic(err)
+	}
+	maintenanceMode = mode
+	if mode.Enabled {
+		blog.Warn("Maintenance mode is on, scheduled backups and updates are paused")
+	}
+}
+
+// GetMaintenanceMode returns whether maintenance mode is on, and why.
+func GetMaintenanceMode() files.MaintenanceMode {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+	return maintenanceMode
+}
+
+// MaintenanceActive returns true if maintenance mode is on.
+func MaintenanceActive() bool {
+	return GetMaintenanceMode().Enabled
+}
+
+// SetMaintenanceMode turns maintenance mode on or off and, if the server's running, runs the enter or exit commands.
+// Returns what the console said. A failed command doesn't stop the mode changing, it's included in the output.
+func SetMaintenanceMode(enabled bool, reason string) (string, error) {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+	changed := maintenanceMode.Enabled != enabled
+	mode := files.MaintenanceMode{Enabled: enabled}
+	if enabled {
+		mode.Reason, mode.Since = reason, time.Now()
+		if !changed {
+			mode.Since = maintenanceMode.Since // just a new reason
+		}
+	}
+	if err := files.SaveMaintenanceMode(mode); err != nil {
+		return "", err
+	}
+	maintenanceMode = mode
+	if !changed {
+		return "", nil
+	}
+
+	commands := files.Config.MaintenanceExitCommands
+	if enabled {
+		blog.Info(fmt.Sprintf("Maintenance mode on: %s", reason))
+		commands = files.Config.MaintenanceEnterCommands
+	} else {
+		blog.Info("Maintenance mode off")
+	}
+	if len(commands) == 0 {
+		return "", nil
+	}
+	if !Process.GetRunning() {
+		blog.Info("Server isn't running, skipped the maintenance mode commands")
+		return "", nil
+	}
+	var output strings.Builder
+	for _, command := range commands {
+		response, err := Process.Console(command)
+		if err != nil {
+			blog.Error(fmt.Sprintf("Maintenance mode command %q failed: %s", command, err.Error()))
+			response = "Failed: " + err.Error()
+		}
+		if response = strings.TrimSpace(response); response != "" {
+			fmt.Fprintf(&output, "> %s\n%s\n", command, response)
+		}
+	}
+	return output.String(), nil
+}
+
+// waitForMaintenance blocks until maintenance mode is off, checking every minute. Returns true if stop was
+// signalled while waiting.
+func waitForMaintenance(stop <-chan struct{}) bool {
+	for MaintenanceActive() {
+		select {
+		case <-time.After(time.Minute):
+		case <-stop:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package game
+
+// game/notify.go is for telling admins about things that happened while they weren't looking, through the
+// configured webhook.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// Notification is the JSON body posted to the webhook. Content repeats the message so Discord webhooks show it as is.
+type Notification struct {
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Output  string    `json:"output,omitempty"`
+	Time    time.Time `json:"time"`
+	Content string    `json:"content"`
+}
+
+var notifyClient = &http.Client{Timeout: 15 * time.Second}
+
+// Notify posts the event to the webhook in the background, it's only logged if there's no webhook configured.
+func Notify(event, message, output string) {
+	blog.Warn(fmt.Sprintf("%s: %s", event, message))
+	if files.Config.NotifyWebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(Notification{Event: event, Message: message, Output: output, Time: time.Now(), Content: message})
+	if err != nil {
+		blog.Error(err.Error())
+		return
+	}
+	go func() {
+		resp, err := notifyClient.Post(files.Config.NotifyWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			blog.Error(fmt.Sprintf("Failed to send %s notification: %s", event, err.Error()))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			blog.Error(fmt.Sprintf("Failed to send %s notification: webhook returned %s", event, resp.Status))
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+package game
+
+// game/playerPolicy.go is for deciding whether scheduled actions like backups and updates go ahead while people are
+// playing, and warning them first when they do.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// player policy actions, see files.PlayerPolicy
+const (
+	PolicyWarn  = "warn"
+	PolicySkip  = "skip"
+	PolicyDefer = "defer"
+)
+
+type policyResult int
+
+const (
+	policyGo   policyResult = iota
+	policySkip              // don't do it this time
+	policyWait              // check again in a minute
+)
+
+// playerPolicy is a files.PlayerPolicy plus how long its action has been waiting for the server to empty.
+type playerPolicy struct {
+	files.PlayerPolicy
+	waitingSince time.Time // zero if it isn't waiting
+}
+
+func newPlayerPolicy(setting string, config files.PlayerPolicy) (*playerPolicy, error) {
+	if config.Action == "" {
+		config.Action = PolicyWarn
+	}
+	switch config.Action {
+	case PolicyWarn, PolicySkip, PolicyDefer:
+	default:
+		return nil, fmt.Errorf("invalid %s action %q, expected %q, %q or %q", setting, config.Action, PolicyWarn, PolicySkip, PolicyDefer)
+	}
+	if config.MaxDelayMins < 0 {
+		return nil, fmt.Errorf("%s max_delay_mins can't be negative", setting)
+	}
+	return &playerPolicy{PlayerPolicy: config}, nil
+}
+
+// check counts the players and returns whether the action should go ahead, and how many are on (-1 if that's
+// unknown). If there's no way to count players it goes ahead, if counting fails players are assumed to be on.
+func (p *playerPolicy) check(now time.Time) (policyResult, int) {
+	count, err := GetPlayerCount()
+	switch {
+	case err == ErrPlayerCountUnknown:
+		if p.Action != PolicyWarn {
+			blog.Warn(fmt.Sprintf("Player policy %q needs a way to count players, going ahead anyway", p.Action))
+		}
+		return p.done(policyGo), -1
+	case err != nil:
+		blog.Error(fmt.Sprintf("Couldn't count players: %s", err.Error()))
+		count = -1
+	case count == 0:
+		return p.done(policyGo), 0
+	}
+
+	switch p.Action {
+	case PolicySkip:
+		return p.done(policySkip), count
+	case PolicyDefer:
+		if p.waitingSince.IsZero() {
+			p.waitingSince = now
+		}
+		if p.MaxDelayMins == 0 || now.Sub(p.waitingSince) < time.Duration(p.MaxDelayMins)*time.Minute {
+			return policyWait, count
+		}
+	}
+	return p.done(policyGo), count
+}
+
+// playersOnline describes a count from check for logs and the dashboard.
+func playersOnline(count int) string {
+	switch count {
+	case -1:
+		return "players might be online"
+	case 1:
+		return "1 player online"
+	}
+	return fmt.Sprintf("%d players online", count)
+}
+
+func (p *playerPolicy) done(result policyResult) policyResult {
+	p.reset()
+	return result
+}
+
+// reset stops waiting, so the max delay starts over the next time the action's due.
+func (p *playerPolicy) reset() {
+	p.waitingSince = time.Time{}
+}
+
+// warnings returns the policy's warning times, or fallback if it has none, longest first without duplicates.
+func (p *playerPolicy) warnings(fallback []int) []int {
+	configured := p.WarningMins
+	if len(configured) == 0 {
+		configured = fallback
+	}
+	seen := map[int]bool{}
+	warnings := []int{}
+	for _, minutes := range configured {
+		if minutes > 0 && !seen[minutes] {
+			seen[minutes] = true
+			warnings = append(warnings, minutes)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(warnings)))
+	return warnings
+}
+
+// warnCountdown counts down through the warnings, e.g. [15, 5, 1] warns "The server will <event> in 15 minutes" at
+// 15, 5 and 1 minutes to go. status is told what's happening. Returns true if stop was signalled while waiting.
+func warnCountdown(warnings []int, event string, status func(string), stop <-chan struct{}) bool {
+	for i, minutes := range warnings {
+		message := fmt.Sprintf("The server will %s in %d minute", event, minutes)
+		if minutes != 1 {
+			message += "s"
+		}
+		if err := WarnPlayers(message); err != nil {
+			blog.Error(fmt.Sprintf("Failed to warn players: %s", err.Error()))
+		}
+		next := 0
+		if i+1 < len(warnings) {
+			next = warnings[i+1]
+		}
+		status(message)
+		select {
+		case <-time.After(time.Duration(minutes-next) * time.Minute):
+		case <-stop:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package game
+
+// game/playerTracking.go is for working out who's online from the join and leave lines the server prints, and
+// recording each player's session so there's a history of who was on when.
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+const playerSessionHeartbeat = time.Minute
+
+var (
+	PlayerTrackingStopChan = make(chan struct{})
+	PlayerTrackingDoneChan = make(chan struct{})
+
+	playerJoinRegex    *regexp.Regexp
+	playerLeaveRegex   *regexp.Regexp
+	onlinePlayers      = map[string]*files.PlayerSession{} // by player ID, or name if there's no ID
+	onlinePlayersMutex sync.Mutex
+)
+
+// PlayerTrackingEnabled returns true if players are tracked from the server's output.
+func PlayerTrackingEnabled() bool {
+	return files.Config.PlayerJoinRegex != ""
+}
+
+// compilePlayerRegex compiles a join or leave regex, which needs a name or id group to say who it's about.
+func compilePlayerRegex(setting, expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", setting, err)
+	}
+	if re.SubexpIndex("name") == -1 && re.SubexpIndex("id") == -1 {
+		return nil, errors.New(setting + " needs a (?P<name>...) or (?P<id>...) group")
+	}
+	return re, nil
+}
+
+// InitPlayerTracking starts watching the server's output for players joining and leaving, if it's configured.
+func InitPlayerTracking() {
+	if !PlayerTrackingEnabled() {
+		return
+	}
+	var err error
+	if playerJoinRegex, err = compilePlayerRegex("player_join_regex", files.Config.PlayerJoinRegex); err != nil {
+		panic(err)
+	}
+	if files.Config.PlayerLeaveRegex != "" {
+		if playerLeaveRegex, err = compilePlayerRegex("player_leave_regex", files.Config.PlayerLeaveRegex); err != nil {
+			panic(err)
+		}
+	}
+
+	// anyone still online when TSM last stopped has gone now
+	count, err := files.EndOpenPlayerSessions(files.PlayerTSMStopped)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		blog.Warn(fmt.Sprintf("Ended %d player sessions left open by the last shutdown", count))
+	}
+
+	Process.OnOutput(handlePlayerLine)
+	Process.OnExit(func() { endOnlinePlayers(files.PlayerServerStopped) })
+	go playerTrackingGoroutine()
+}
+
+func StopPlayerTracking() {
+	if !PlayerTrackingEnabled() {
+		return
+	}
+	PlayerTrackingStopChan <- struct{}{}
+	<-PlayerTrackingDoneChan
+}
+
+// playerTrackingGoroutine keeps open sessions' last update current, see files.EndOpenPlayerSessions.
+func playerTrackingGoroutine() {
+	ticker := time.NewTicker(playerSessionHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := files.TouchOpenPlayerSessions(); err != nil {
+				blog.Error(err.Error())
+			}
+		case <-PlayerTrackingStopChan:
+			PlayerTrackingDoneChan <- struct{}{}
+			return
+		}
+	}
+}
+
+// GetOnlinePlayers returns the sessions of everyone online, longest first.
+func GetOnlinePlayers() []files.PlayerSession {
+	onlinePlayersMutex.Lock()
+	defer onlinePlayersMutex.Unlock()
+	players := []files.PlayerSession{}
+	for _, session := range onlinePlayers {
+		players = append(players, *session)
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].JoinedAt.Before(players[j].JoinedAt) })
+	return players
+}
+
+// playerFromMatch returns the name and ID from a join or leave line. Either can be empty, but not both.
+func playerFromMatch(re *regexp.Regexp, match []string) (name, playerID string) {
+	if i := re.SubexpIndex("name"); i != -1 {
+		name = match[i]
+	}
+	if i := re.SubexpIndex("id"); i != -1 {
+		playerID = match[i]
+	}
+	if name == "" {
+		name = playerID
+	}
+	return name, playerID
+}
+
+func handlePlayerLine(line string) {
+	if match := playerJoinRegex.FindStringSubmatch(line); match != nil {
+		name, playerID := playerFromMatch(playerJoinRegex, match)
+		if name == "" {
+			return
+		}
+		playerJoined(name, playerID)
+		return
+	}
+	if playerLeaveRegex != nil {
+		if match := playerLeaveRegex.FindStringSubmatch(line); match != nil {
+			playerLeft(playerFromMatch(playerLeaveRegex, match))
+		}
+	}
+}
+
+func playerJoined(name, playerID string) {
+	key := playerID
+	if key == "" {
+		key = name
+	}
+	onlinePlayersMutex.Lock()
+	defer onlinePlayersMutex.Unlock()
+	if _, ok := onlinePlayers[key]; ok {
+		return // some servers print the join line more than once
+	}
+	session, err := files.StartPlayerSession(name, playerID, time.Now())
+	if err != nil {
+		blog.Error(err.Error())
+		return
+	}
+	onlinePlayers[key] = &session
+	blog.Info(fmt.Sprintf("Player %s joined", name))
+}
+
+func playerLeft(name, playerID string) {
+	onlinePlayersMutex.Lock()
+	defer onlinePlayersMutex.Unlock()
+	// find them by ID if the leave line has one, falling back to the name
+	key, found := playerID, playerID != ""
+	if _, ok := onlinePlayers[key]; !found || !ok {
+		found = false
+		for otherKey, session := range onlinePlayers {
+			if session.Name == name {
+				key, found = otherKey, true
+				break
+			}
+		}
+	}
+	if !found {
+		return
+	}
+	session := onlinePlayers[key]
+	if err := files.EndPlayerSession(session, time.Now(), files.PlayerLeft); err != nil {
+		blog.Error(err.Error())
+	}
+	delete(onlinePlayers, key)
+	blog.Info(fmt.Sprintf("Player %s left", session.Name))
+}
+
+// endOnlinePlayers ends everyone's session, e.g. when the server stops.
+func endOnlinePlayers(reason string) {
+	onlinePlayersMutex.Lock()
+	defer onlinePlayersMutex.Unlock()
+	now := time.Now()
+	for key, session := range onlinePlayers {
+		if err := files.EndPlayerSession(session, now, reason); err != nil {
+			blog.Error(err.Error())
+		}
+		delete(onlinePlayers, key)
+	}
+}
@@ -0,0 +1,79 @@
+package game
+
+// game/players.go is for finding out who's playing and talking to them, through the configured commands or the
+// server's console.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tsm/src/files"
+)
+
+const playerCommandTimeout = 30 * time.Second
+
+var ErrPlayerCountUnknown = errors.New("no way to count players is configured")
+
+// GetPlayerCount returns how many players are online, or ErrPlayerCountUnknown. It uses player_count_command if
+// it's set, then the server query, then RCON, then the players seen joining in the server's output.
+func GetPlayerCount() (int, error) {
+	if len(files.Config.PlayerCountCommand) > 0 {
+		return commandPlayerCount()
+	}
+	viaRcon := RconEnabled() && files.Config.RconPlayerCountCommand != ""
+	if !QueryEnabled() && !viaRcon && !PlayerTrackingEnabled() {
+		return 0, ErrPlayerCountUnknown
+	}
+	if Process == nil || !Process.GetRunning() {
+		return 0, nil // nobody's on a stopped server, and there's nothing to ask
+	}
+	switch {
+	case QueryEnabled():
+		return queryPlayerCount()
+	case viaRcon:
+		return rconPlayerCount()
+	}
+	return len(GetOnlinePlayers()), nil
+}
+
+// commandPlayerCount runs player_count_command.
+func commandPlayerCount() (int, error) {
+	var output bytes.Buffer
+	if err := runCommand(&output, playerCommandTimeout, files.Config.PlayerCountCommand); err != nil {
+		return 0, err
+	}
+	// the count is the last thing printed, anything before it is ignored
+	lines := strings.Fields(output.String())
+	if len(lines) == 0 {
+		return 0, errors.New("player count command didn't print anything")
+	}
+	count, err := strconv.Atoi(lines[len(lines)-1])
+	if err != nil {
+		return 0, fmt.Errorf("player count command printed %q, not a number", lines[len(lines)-1])
+	}
+	return count, nil
+}
+
+// WarnPlayers sends a message to everyone on the server, it does nothing if there's no warn command.
+func WarnPlayers(message string) error {
+	if len(files.Config.WarnCommand) == 0 {
+		if files.Config.ConsoleWarnCommand == "" {
+			return nil
+		}
+		_, err := Process.Console(strings.ReplaceAll(files.Config.ConsoleWarnCommand, "{message}", message))
+		return err
+	}
+	args := make([]string, len(files.Config.WarnCommand))
+	for i, arg := range files.Config.WarnCommand {
+		args[i] = strings.ReplaceAll(arg, "{message}", message)
+	}
+	var output bytes.Buffer
+	if err := runCommand(&output, playerCommandTimeout, args); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
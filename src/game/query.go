@@ -0,0 +1,143 @@
+package game
+
+// game/query.go is for polling the game server's Steam query port for its players, map and version, and recording
+// the player count over time.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"tsm/src/a2s"
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+const queryTimeout = 5 * time.Second
+
+var (
+	QueryStopChan = make(chan struct{})
+	QueryDoneChan = make(chan struct{})
+
+	serverStatus      ServerStatus
+	serverStatusMutex sync.Mutex
+)
+
+// ServerStatus is what the game server said the last time it was queried.
+type ServerStatus struct {
+	Enabled bool
+	Online  bool // answered the last query
+	Info    a2s.Info
+	Players []a2s.Player // empty if the server doesn't list players
+	Updated time.Time
+	Error   string // why the last query failed
+}
+
+// QueryEnabled returns true if there's a query port to poll.
+func QueryEnabled() bool {
+	return files.Config.QueryAddress != ""
+}
+
+// GetServerStatus returns the result of the last query.
+func GetServerStatus() ServerStatus {
+	serverStatusMutex.Lock()
+	defer serverStatusMutex.Unlock()
+	return serverStatus
+}
+
+func queryInterval() time.Duration {
+	if files.Config.QueryIntervalSecs <= 0 {
+		return time.Minute
+	}
+	return time.Duration(files.Config.QueryIntervalSecs) * time.Second
+}
+
+// InitQuery starts polling the query port if one is configured.
+func InitQuery() {
+	if !QueryEnabled() {
+		return
+	}
+	serverStatusMutex.Lock()
+	serverStatus.Enabled = true
+	serverStatusMutex.Unlock()
+	go queryGoroutine()
+}
+
+func StopQuery() {
+	if !QueryEnabled() {
+		return
+	}
+	QueryStopChan <- struct{}{}
+	<-QueryDoneChan
+}
+
+func queryGoroutine() {
+	ticker := time.NewTicker(queryInterval())
+	defer ticker.Stop()
+	for {
+		pollServer()
+		select {
+		case <-ticker.C:
+		case <-QueryStopChan:
+			QueryDoneChan <- struct{}{}
+			return
+		}
+	}
+}
+
+// pollServer queries the server and records the player count. A stopped server counts as empty, but a running
+// one that doesn't answer (e.g. still starting) isn't recorded.
+func pollServer() {
+	status := ServerStatus{Enabled: true, Updated: time.Now()}
+	if !Process.GetRunning() {
+		status.Error = "Server isn't running"
+		setServerStatus(status)
+		if err := files.RecordPlayerCount(0, GetServerStatus().Info.MaxPlayers); err != nil {
+			blog.Error(err.Error())
+		}
+		return
+	}
+
+	info, err := a2s.QueryInfo(files.Config.QueryAddress, queryTimeout)
+	if err != nil {
+		status.Error = "Query failed: " + err.Error()
+		setServerStatus(status)
+		blog.Debug(status.Error)
+		return
+	}
+	status.Online = true
+	status.Info = info
+	// the player list is a nice extra, plenty of servers don't fill it in
+	if status.Players, err = a2s.QueryPlayers(files.Config.QueryAddress, queryTimeout); err != nil {
+		blog.Debug(fmt.Sprintf("Player query failed: %s", err.Error()))
+	}
+	setServerStatus(status)
+	if err := files.RecordPlayerCount(info.Players, info.MaxPlayers); err != nil {
+		blog.Error(err.Error())
+	}
+}
+
+func setServerStatus(status ServerStatus) {
+	serverStatusMutex.Lock()
+	defer serverStatusMutex.Unlock()
+	// keep what the server said last time it was up, so the dashboard still has the map etc.
+	if !status.Online {
+		status.Info, status.Players = serverStatus.Info, nil
+		status.Info.Players = 0
+	}
+	serverStatus = status
+}
+
+// queryPlayerCount returns the player count from the last query if it's recent, otherwise it asks the server.
+func queryPlayerCount() (int, error) {
+	status := GetServerStatus()
+	if status.Online && time.Since(status.Updated) < queryInterval()*2 {
+		return status.Info.Players, nil
+	}
+	info, err := a2s.QueryInfo(files.Config.QueryAddress, queryTimeout)
+	if err != nil {
+		return 0, err
+	}
+	return info.Players, nil
+}
@@ -0,0 +1,229 @@
+package game
+
+// game/rollback.go is for rolling back updates that leave the server unhealthy. The install directory and save are
+// snapshotted before updating, and put back if the update fails or the server doesn't stay healthy for the
+// configured health window after starting.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+const (
+	preUpdateSnapshotName = "pre-update"
+	healthCheckInterval   = 5 * time.Second
+	healthCheckTimeout    = 30 * time.Second
+	notifyOutputMaxBytes  = 64 << 10 // only the end of the output is sent with notifications
+)
+
+var ErrUpdateRolledBack = errors.New("update rolled back")
+
+// RollbackEnabled returns true if updates are health checked and rolled back.
+func RollbackEnabled() bool {
+	return files.Config.UpdateHealthWindowSecs > 0
+}
+
+// updateWithRollback snapshots the install directory and save, updates and starts the server, then restores the
+// snapshot if the update failed or the server isn't healthy. Assumes server is stopped and Mutex is locked.
+func updateWithRollback(progress files.Progress) error {
+	recorder := &outputRecorder{Progress: progress}
+	progress = recorder
+
+	progress.Logf("Snapshotting install directory and save")
+	snapshot, err := files.CreateSnapshot(preUpdateSnapshotName, progress)
+	if err != nil {
+		progress.Logf("Snapshot failed, not updating: %s", err.Error())
+		progress.Logf("Starting game server")
+		if startErr := Process.Start(); startErr != nil {
+			return startErr
+		}
+		return fmt.Errorf("couldn't snapshot before updating: %w", err)
+	}
+
+	before, _ := Updater.InstalledVersion()
+	progress.Logf("Updating game server")
+	attempted := ""
+	reason := Update(progress)
+	if reason == nil {
+		if after, _ := Updater.InstalledVersion(); after != before {
+			attempted = after
+		}
+		reason = startAndWatch(progress)
+	}
+	if reason == nil {
+		progress.Logf("Server is healthy")
+		releaseUpdateHold()
+		if err := files.DeleteSnapshot(snapshot); err != nil {
+			blog.Error(fmt.Sprintf("Failed to delete pre-update snapshot: %s", err.Error()))
+		}
+		return nil
+	}
+
+	progress.Logf("Update failed, rolling back: %s", reason.Error())
+	if err := rollback(snapshot, progress); err != nil {
+		progress.Logf("Rollback failed: %s", err.Error())
+		Notify("update_rollback_failed", fmt.Sprintf("Update failed (%s) and rolling back failed: %s", reason.Error(), err.Error()), recorder.String())
+		return fmt.Errorf("update failed (%s) and rolling back failed: %w", reason.Error(), err)
+	}
+	progress.Logf("Rolled back")
+	if attempted != "" {
+		if err := files.MarkGameVersionRolledBack(attempted); err != nil {
+			blog.Error(fmt.Sprintf("Failed to mark build %s as rolled back: %s", attempted, err.Error()))
+		}
+	}
+	Notify("update_rolled_back", fmt.Sprintf("Update rolled back: %s", reason.Error()), recorder.String())
+	return fmt.Errorf("%w: %w", ErrUpdateRolledBack, reason)
+}
+
+// startAndWatch starts the server and waits out the health window, returning why it's unhealthy if it is.
+func startAndWatch(progress files.Progress) error {
+	watcher, err := newHealthWatcher(progress)
+	if err != nil {
+		return err
+	}
+	defer watcher.stop()
+
+	progress.Logf("Starting game server")
+	if err := Process.Start(); err != nil {
+		return err
+	}
+	progress.Logf("Watching the server for %d seconds", files.Config.UpdateHealthWindowSecs)
+	return watcher.wait(progress)
+}
+
+// rollback stops the server if it's still running, restores the snapshot and starts the server again.
+func rollback(snapshot files.Backup, progress files.Progress) error {
+	if Process.GetRunning() {
+		progress.Logf("Stopping game server")
+		if err := Process.Stop(); err != nil {
+			progress.Logf("Server exited with: %s", err.Error()) // it likely crashed, that's why we're here
+		}
+	}
+	progress.Logf("Restoring snapshot")
+	if err := files.RestoreSnapshot(snapshot, progress); err != nil {
+		return err
+	}
+	if _, err := recordInstalledVersion(); err != nil {
+		progress.Logf("Couldn't record installed build: %s", err.Error())
+	}
+	if err := files.DeleteSnapshot(snapshot); err != nil {
+		blog.Error(fmt.Sprintf("Failed to delete pre-update snapshot: %s", err.Error()))
+	}
+	progress.Logf("Starting game server")
+	return Process.Start()
+}
+
+// ==== Health ================================================================
+
+// healthWatcher checks the server stays up, prints its ready line and passes the health check within the health
+// window. It must be created before the server is started so the ready line isn't missed. What the server prints
+// meanwhile is copied to output, so there's something to go on if it crashes.
+type healthWatcher struct {
+	ready         chan struct{}
+	readyOnce     sync.Once
+	stopListening func()
+}
+
+func newHealthWatcher(output io.Writer) (*healthWatcher, error) {
+	hw := &healthWatcher{ready: make(chan struct{})}
+	var readyRegex *regexp.Regexp
+	if files.Config.UpdateReadyRegex == "" {
+		close(hw.ready)
+	} else {
+		var err error
+		if readyRegex, err = regexp.Compile(files.Config.UpdateReadyRegex); err != nil {
+			return nil, err
+		}
+	}
+	hw.stopListening = Process.OnOutput(func(line string) {
+		io.WriteString(output, line+"\n")
+		if readyRegex != nil && readyRegex.MatchString(line) {
+			hw.readyOnce.Do(func() { close(hw.ready) })
+		}
+	})
+	return hw, nil
+}
+
+func (hw *healthWatcher) stop() {
+	hw.stopListening()
+}
+
+// wait blocks until the health window is over, returning early if the server exits. Assumes server was just started.
+func (hw *healthWatcher) wait(progress files.Progress) error {
+	window := time.Duration(files.Config.UpdateHealthWindowSecs) * time.Second
+	deadline := time.NewTimer(window)
+	defer deadline.Stop()
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	ready := hw.ready
+	isReady := false
+	passed := len(files.Config.UpdateHealthCheck) == 0
+	for {
+		select {
+		case <-Process.Exited():
+			return errors.New("server exited during the health window")
+		case <-ready:
+			if files.Config.UpdateReadyRegex != "" {
+				progress.Logf("Server is ready")
+			}
+			isReady = true
+			ready = nil
+		case <-ticker.C:
+			if isReady && !passed {
+				passed = runHealthCheck(progress) == nil
+			}
+		case <-deadline.C:
+			if !isReady {
+				return fmt.Errorf("server didn't print a line matching %q within %s", files.Config.UpdateReadyRegex, window)
+			}
+			if !passed {
+				if err := runHealthCheck(progress); err != nil {
+					return fmt.Errorf("health check didn't pass within %s: %w", window, err)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+func runHealthCheck(progress files.Progress) error {
+	err := runCommand(progress, healthCheckTimeout, files.Config.UpdateHealthCheck)
+	if err == nil {
+		progress.Logf("Health check passed")
+	}
+	return err
+}
+
+// ==== Output ================================================================
+
+// outputRecorder keeps the end of the command output written to a job, so it can be sent with notifications.
+type outputRecorder struct {
+	files.Progress
+	mutex  sync.Mutex
+	output []byte
+}
+
+func (or *outputRecorder) Write(p []byte) (int, error) {
+	or.mutex.Lock()
+	or.output = append(or.output, p...)
+	if len(or.output) > notifyOutputMaxBytes {
+		or.output = or.output[len(or.output)-notifyOutputMaxBytes:]
+	}
+	or.mutex.Unlock()
+	return or.Progress.Write(p)
+}
+
+func (or *outputRecorder) String() string {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
+	return string(or.output)
+}
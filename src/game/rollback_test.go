@@ -0,0 +1,105 @@
+package game
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/src/files"
+)
+
+// setupDefaultUpdate lays out a server the default way, with the game exe next to TSM and no install_dir, and starts
+// it with the config a new config.json gets. The exe exits straight away if there's a file called crash.
+func setupDefaultUpdate(t *testing.T, updateScript string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	files.InitDatabase()
+	config := files.Config
+	t.Cleanup(func() {
+		if Process != nil && Process.GetRunning() {
+			Process.Stop()
+		}
+		files.Config = config
+		files.CloseDatabase()
+		os.Chdir(wd)
+	})
+
+	writeScript(t, "server.sh", "if [ -f crash ]; then exit 1; fi\nexec sleep 600\n")
+	writeScript(t, "update.sh", updateScript)
+	if err := os.MkdirAll("world", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("world", "level.dat"), []byte("good"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files.Config = files.DefaultConfig()
+	files.Config.GameExePath = "server.sh"
+	files.Config.GameSavePath = "world"
+	files.Config.UpdateCommand = "sh update.sh"
+	// rollback stays on like the default, the window's just shorter so the test doesn't take two minutes
+	files.Config.UpdateHealthWindowSecs = 1
+	files.InitBackupPaths()
+	InitUpdateProvider()
+	InitGameServer()
+	// the process is started in the background
+	for deadline := time.Now().Add(5 * time.Second); !Process.GetRunning(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server didn't start")
+		}
+	}
+}
+
+func writeScript(t *testing.T, name, script string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateWithDefaultConfig(t *testing.T) {
+	setupDefaultUpdate(t, "echo 2 > version.txt\n")
+	if !RollbackEnabled() {
+		t.Fatal("rollback should be on by default")
+	}
+	if err := UpdateJob()(testProgress{Writer: io.Discard}); err != nil {
+		t.Fatalf("update failed: %s", err)
+	}
+	if version, err := os.ReadFile("version.txt"); err != nil || string(version) != "2\n" {
+		t.Fatalf("update command didn't run: %q (%v)", version, err)
+	}
+	if !Process.GetRunning() {
+		t.Fatal("server should be running after the update")
+	}
+	if snapshots, _ := filepath.Glob(filepath.Join(files.BackupsPath, preUpdateSnapshotName+".*")); len(snapshots) != 0 {
+		t.Fatalf("snapshot wasn't cleaned up: %v", snapshots)
+	}
+}
+
+func TestUpdateRollbackWithDefaultConfig(t *testing.T) {
+	// the update breaks the save and leaves the server crashing
+	setupDefaultUpdate(t, "echo bad > world/level.dat\ntouch crash\n")
+	// long enough to see the crash, exits are only noticed a few seconds after they happen
+	files.Config.UpdateHealthWindowSecs = 5
+	err := UpdateJob()(testProgress{Writer: io.Discard})
+	if !errors.Is(err, ErrUpdateRolledBack) {
+		t.Fatalf("expected the update to be rolled back, got %v", err)
+	}
+	// only the save is snapshotted when the install directory is TSM's own
+	if level, err := os.ReadFile(filepath.Join("world", "level.dat")); err != nil || string(level) != "good" {
+		t.Fatalf("save wasn't rolled back: %q (%v)", level, err)
+	}
+	if !files.FileExists("crash") {
+		t.Fatal("files in TSM's own directory shouldn't be touched by the rollback")
+	}
+}
@@ -0,0 +1,227 @@
+package game
+
+// game/steam.go is the SteamCMD update provider. The installed build comes from the app's appmanifest_<id>.acf,
+// and the latest from `steamcmd +app_info_print`, both of which are in Valve's KeyValues (VDF) text format.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tsm/src/files"
+)
+
+type steamCMDProvider struct {
+	steamcmd   string
+	appID      string
+	installDir string
+	branch     string
+	login      string
+}
+
+func newSteamCMDProvider() (*steamCMDProvider, error) {
+	config := files.Config
+	if config.SteamAppID == "" || config.SteamInstallDir == "" {
+		return nil, errors.New("steamcmd update provider needs steam_app_id and steam_install_dir")
+	}
+	provider := &steamCMDProvider{
+		steamcmd:   config.SteamCMDPath,
+		appID:      config.SteamAppID,
+		installDir: config.SteamInstallDir,
+		branch:     config.SteamBranch,
+		login:      config.SteamLogin,
+	}
+	if provider.steamcmd == "" {
+		provider.steamcmd = "steamcmd"
+	}
+	if provider.login == "" {
+		provider.login = "anonymous"
+	}
+	return provider, nil
+}
+
+func (s *steamCMDProvider) Name() string { return "steamcmd" }
+
+func (s *steamCMDProvider) manifestPath() string {
+	return filepath.Join(s.installDir, "steamapps", "appmanifest_"+s.appID+".acf")
+}
+
+func (s *steamCMDProvider) InstalledVersion() (string, error) {
+	data, err := os.ReadFile(s.manifestPath())
+	if os.IsNotExist(err) {
+		return "", nil // not installed through steamcmd yet
+	} else if err != nil {
+		return "", err
+	}
+	manifest, err := parseVDF(string(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", s.manifestPath(), err)
+	}
+	return manifest.get("appstate", "buildid"), nil
+}
+
+func (s *steamCMDProvider) LatestVersion() (string, error) {
+	// +app_info_update makes sure the cached app info isn't stale
+	var buf bytes.Buffer
+	err := runCommand(&buf, updateCheckTimeout, []string{s.steamcmd, "+login", s.login, "+app_info_update", "1", "+app_info_print", s.appID, "+quit"})
+	if err != nil {
+		return "", err
+	}
+	output := buf.Bytes()
+
+	// the app info is printed as `"<appid>" { ... }` after a bunch of log lines
+	start := strings.Index(string(output), `"`+s.appID+`"`)
+	if start == -1 {
+		return "", errors.New("steamcmd didn't print the app info")
+	}
+	info, err := parseVDF(string(output[start:]))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse steamcmd app info: %w", err)
+	}
+	branch := s.branch
+	if branch == "" {
+		branch = "public"
+	}
+	buildID := info.get(s.appID, "depots", "branches", branch, "buildid")
+	if buildID == "" {
+		return "", fmt.Errorf("steamcmd app info has no build id for branch %s", branch)
+	}
+	return buildID, nil
+}
+
+func (s *steamCMDProvider) Update(progress files.Progress) error {
+	args := []string{"+force_install_dir", s.installDir, "+login", s.login, "+app_update", s.appID}
+	if s.branch != "" {
+		args = append(args, "-beta", s.branch)
+	}
+	args = append(args, "validate", "+quit")
+
+	progress.Logf("Running steamcmd %s", strings.Join(args, " "))
+	return runCommand(progress, updateTimeout(), append([]string{s.steamcmd}, args...))
+}
+
+// ==== KeyValues =============================================================
+
+// vdfNode is a parsed KeyValues block, values are either strings or nested vdfNodes. Keys are lowercased since
+// KeyValues lookups are case insensitive.
+type vdfNode map[string]any
+
+// get follows the keys down through nested blocks, returning the string at the end or "" if it isn't there.
+func (n vdfNode) get(keys ...string) string {
+	node := n
+	for i, key := range keys {
+		value, ok := node[strings.ToLower(key)]
+		if !ok {
+			return ""
+		}
+		if i == len(keys)-1 {
+			str, _ := value.(string)
+			return str
+		}
+		if node, ok = value.(vdfNode); !ok {
+			return ""
+		}
+	}
+	return ""
+}
+
+// parseVDF parses KeyValues text up to the end of its first top level block, ignoring anything after.
+func parseVDF(text string) (vdfNode, error) {
+	tokens, err := tokenizeVDF(text)
+	if err != nil {
+		return nil, err
+	}
+	root := vdfNode{}
+	stack := []vdfNode{root}
+	for i := 0; i < len(tokens); i++ {
+		current := stack[len(stack)-1]
+		switch tokens[i] {
+		case "{":
+			return nil, errors.New("unexpected {")
+		case "}":
+			if len(stack) == 1 {
+				return nil, errors.New("unexpected }")
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 1 {
+				return root, nil // done with the first top level block
+			}
+			continue
+		}
+		if i+1 >= len(tokens) {
+			return nil, errors.New("key without a value: " + tokens[i])
+		}
+		key := strings.ToLower(tokens[i])
+		if tokens[i+1] == "{" {
+			child := vdfNode{}
+			current[key] = child
+			stack = append(stack, child)
+		} else if tokens[i+1] == "}" {
+			return nil, errors.New("key without a value: " + tokens[i])
+		} else {
+			current[key] = tokens[i+1]
+		}
+		i++
+	}
+	if len(stack) != 1 {
+		return nil, errors.New("unexpected end of input")
+	}
+	return root, nil
+}
+
+// tokenizeVDF splits KeyValues text into quoted or bare strings and braces, dropping // comments.
+// Braces are returned as "{" and "}", so quoted strings of just a brace can't be told apart, which is fine here.
+func tokenizeVDF(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '/' && i+1 < len(text) && text[i+1] == '/':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			var token strings.Builder
+			i++
+			for {
+				if i >= len(text) {
+					return nil, errors.New("unterminated string")
+				}
+				if text[i] == '"' {
+					i++
+					break
+				}
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+					switch text[i] {
+					case 'n':
+						token.WriteByte('\n')
+					case 't':
+						token.WriteByte('\t')
+					default:
+						token.WriteByte(text[i])
+					}
+				} else {
+					token.WriteByte(text[i])
+				}
+				i++
+			}
+			tokens = append(tokens, token.String())
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t\r\n{}\"", rune(text[i])) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+	return tokens, nil
+}
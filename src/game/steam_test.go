@@ -0,0 +1,241 @@
+package game
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tsm/src/files"
+)
+
+func TestParseVDF(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		keys  []string
+		want  string
+		error bool
+	}{
+		{
+			name: "appmanifest",
+			text: "\"AppState\"\n{\n\t\"appid\"\t\t\"896660\"\n\t\"buildid\"\t\t\"14016455\"\n}\n",
+			keys: []string{"appstate", "buildid"},
+			want: "14016455",
+		},
+		{
+			name: "nested",
+			text: `"896660" { "depots" { "branches" { "public" { "buildid" "123" } "beta" { "buildid" "456" } } } }`,
+			keys: []string{"896660", "depots", "branches", "beta", "buildid"},
+			want: "456",
+		},
+		{
+			name: "keys are case insensitive",
+			text: `"AppState" { "BuildID" "7" }`,
+			keys: []string{"APPSTATE", "buildId"},
+			want: "7",
+		},
+		{
+			name: "comments",
+			text: "// made by steamcmd\n\"a\" {\n\t\"b\" \"1\" // the build\n}",
+			keys: []string{"a", "b"},
+			want: "1",
+		},
+		{
+			name: "bare strings",
+			text: "a { b 1 }",
+			keys: []string{"a", "b"},
+			want: "1",
+		},
+		{
+			name: "escapes",
+			text: `"a" { "b" "say \"hi\"\tnow\\" }`,
+			keys: []string{"a", "b"},
+			want: "say \"hi\"\tnow\\",
+		},
+		{
+			name: "stops after the first block",
+			text: `"a" { "b" "1" } steamcmd prints "this" { after`,
+			keys: []string{"a", "b"},
+			want: "1",
+		},
+		{
+			name: "missing key",
+			text: `"a" { "b" "1" }`,
+			keys: []string{"a", "c"},
+			want: "",
+		},
+		{
+			name: "string where a block's expected",
+			text: `"a" { "b" "1" }`,
+			keys: []string{"a", "b", "c"},
+			want: "",
+		},
+		{name: "unterminated string", text: `"a" { "b" "1 }`, error: true},
+		{name: "unclosed block", text: `"a" { "b" "1"`, error: true},
+		{name: "unexpected close", text: `}`, error: true},
+		{name: "unexpected open", text: `{ "a" "1" }`, error: true},
+		{name: "key without a value", text: `"a" { "b" }`, error: true},
+		{name: "key at the end", text: `"a"`, error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := parseVDF(test.text)
+			if test.error {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", node)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := node.get(test.keys...); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// appInfoOutput is roughly what `steamcmd +app_info_print` prints, log lines and all.
+const appInfoOutput = `Redirecting stderr to '/home/steam/Steam/logs/stderr.txt'
+[  0%] Checking for available updates...
+Connecting anonymously to Steam Public...OK
+AppID : 896660, change number : 22880000/0, last change : Tue Oct 14 09:12:33 2026
+"896660"
+{
+	"common"
+	{
+		"name"		"Valheim Dedicated Server"
+	}
+	"depots"
+	{
+		"branches"
+		{
+			"public"
+			{
+				"buildid"		"20431577"
+				"timeupdated"		"1791969153"
+			}
+			"public-test"
+			{
+				"buildid"		"20499120"
+				"pwdrequired"		"1"
+			}
+		}
+	}
+}
+Unloading Steam API...OK
+`
+
+// fakeSteamCMD writes a script that records its arguments and prints output, and points the steamcmd provider at it.
+func fakeSteamCMD(t *testing.T, output string) (provider *steamCMDProvider, argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	if err := os.WriteFile(filepath.Join(dir, "output"), []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"$@\" > '" + argsFile + "'\ncat '" + filepath.Join(dir, "output") + "'\n"
+	steamcmd := filepath.Join(dir, "steamcmd")
+	if err := os.WriteFile(steamcmd, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := files.Config
+	t.Cleanup(func() { files.Config = config })
+	files.Config.SteamCMDPath = steamcmd
+	files.Config.SteamAppID = "896660"
+	files.Config.SteamInstallDir = filepath.Join(dir, "server")
+	provider, err := newSteamCMDProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, argsFile
+}
+
+func readArgs(t *testing.T, argsFile string) string {
+	t.Helper()
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(args))
+}
+
+func TestSteamCMDLatestVersion(t *testing.T) {
+	provider, argsFile := fakeSteamCMD(t, appInfoOutput)
+	latest, err := provider.LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest != "20431577" {
+		t.Fatalf("got build %q, want 20431577", latest)
+	}
+	if args := readArgs(t, argsFile); args != "+login anonymous +app_info_update 1 +app_info_print 896660 +quit" {
+		t.Fatalf("unexpected args %q", args)
+	}
+
+	provider.branch = "public-test"
+	if latest, err = provider.LatestVersion(); err != nil || latest != "20499120" {
+		t.Fatalf("got build %q (%v), want 20499120", latest, err)
+	}
+
+	provider.branch = "nope"
+	if _, err := provider.LatestVersion(); err == nil {
+		t.Fatal("expected an error for a branch that doesn't exist")
+	}
+}
+
+func TestSteamCMDLatestVersionNoAppInfo(t *testing.T) {
+	provider, _ := fakeSteamCMD(t, "Connecting anonymously to Steam Public...FAILED\n")
+	if _, err := provider.LatestVersion(); err == nil {
+		t.Fatal("expected an error when steamcmd doesn't print the app info")
+	}
+}
+
+func TestSteamCMDInstalledVersion(t *testing.T) {
+	provider, _ := fakeSteamCMD(t, "")
+	installed, err := provider.InstalledVersion()
+	if err != nil || installed != "" {
+		t.Fatalf("got %q (%v) before installing, want nothing", installed, err)
+	}
+
+	manifest := "\"AppState\"\n{\n\t\"appid\"\t\t\"896660\"\n\t\"buildid\"\t\t\"20431577\"\n}\n"
+	if err := os.MkdirAll(filepath.Dir(provider.manifestPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(provider.manifestPath(), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if installed, err = provider.InstalledVersion(); err != nil || installed != "20431577" {
+		t.Fatalf("got %q (%v), want 20431577", installed, err)
+	}
+}
+
+func TestSteamCMDUpdate(t *testing.T) {
+	provider, argsFile := fakeSteamCMD(t, "Success! App '896660' fully installed.\n")
+	provider.branch = "public-test"
+	var output bytes.Buffer
+	if err := provider.Update(&testProgress{Writer: &output}); err != nil {
+		t.Fatal(err)
+	}
+	want := "+force_install_dir " + provider.installDir + " +login anonymous +app_update 896660 -beta public-test validate +quit"
+	if args := readArgs(t, argsFile); args != want {
+		t.Fatalf("got args %q, want %q", args, want)
+	}
+	if !strings.Contains(output.String(), "fully installed") {
+		t.Fatalf("steamcmd's output wasn't passed on: %q", output.String())
+	}
+}
+
+// testProgress keeps a job's command output and ignores the rest.
+type testProgress struct {
+	io.Writer
+}
+
+func (testProgress) SetTotal(int64, int64) {}
+func (testProgress) Advance(int64, int64)  {}
+func (testProgress) Logf(string, ...any)   {}
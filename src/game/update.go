@@ -0,0 +1,195 @@
+package game
+
+// game/update.go is for updating the game server through the configured update provider, and keeping track of
+// which build is installed.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// updateCheckTimeout is how long checking for the latest build can take.
+const updateCheckTimeout = 2 * time.Minute
+
+var (
+	ErrUpdateCheckUnsupported = errors.New("update provider can't check for updates")
+	Updater                   UpdateProvider
+)
+
+// UpdateProvider is implemented by each way of updating the game server.
+type UpdateProvider interface {
+	Name() string
+	// InstalledVersion returns the installed build, "" if the provider can't tell.
+	InstalledVersion() (string, error)
+	// LatestVersion returns the newest available build, or ErrUpdateCheckUnsupported.
+	LatestVersion() (string, error)
+	// Update installs the newest build. Assumes server is stopped.
+	Update(progress files.Progress) error
+}
+
+// UpdateStatus is the result of checking for an update.
+type UpdateStatus struct {
+	Installed       string
+	Latest          string
+	UpdateAvailable bool
+}
+
+// InitUpdateProvider sets up the configured update provider and records the installed build,
+// in case the server was updated outside of TSM.
+func InitUpdateProvider() {
+	switch files.Config.UpdateProvider {
+	case "", "command":
+		Updater = commandProvider{}
+	case "steamcmd":
+		provider, err := newSteamCMDProvider()
+		if err != nil {
+			panic(err)
+		}
+		Updater = provider
+	default:
+		panic("unknown update provider: " + files.Config.UpdateProvider)
+	}
+
+	// make sure the ready line regex is usable now, rather than after an update
+	if _, err := regexp.Compile(files.Config.UpdateReadyRegex); err != nil {
+		panic("invalid update_ready_regex: " + err.Error())
+	}
+
+	if _, err := recordInstalledVersion(); err != nil {
+		blog.Error(fmt.Sprintf("Failed to read installed game server build: %s", err.Error()))
+	}
+}
+
+// recordInstalledVersion adds the installed build to the version history if it changed, returning it.
+func recordInstalledVersion() (string, error) {
+	buildID, err := Updater.InstalledVersion()
+	if err != nil {
+		return "", err
+	}
+	if _, err := files.RecordGameVersion(buildID, Updater.Name()); err != nil {
+		return buildID, err
+	}
+	return buildID, nil
+}
+
+// Update updates the server using the configured provider, recording the build before and after.
+// Assumes server is stopped and Mutex is locked.
+func Update(progress files.Progress) error {
+	before, err := Updater.InstalledVersion()
+	if err != nil {
+		progress.Logf("Couldn't read installed build: %s", err.Error())
+	}
+
+	if err := Updater.Update(progress); err != nil {
+		return err
+	}
+
+	after, err := recordInstalledVersion()
+	if err != nil {
+		progress.Logf("Couldn't record installed build: %s", err.Error())
+		return nil
+	}
+	switch {
+	case after == "":
+	case before == after:
+		progress.Logf("Already up to date, build %s", after)
+	default:
+		progress.Logf("Updated from build %s to %s", before, after)
+		blog.Info(fmt.Sprintf("Updated game server from build %s to %s", before, after))
+	}
+	return nil
+}
+
+// CheckForUpdate asks the provider whether there's a newer build than the installed one, or runs the update check
+// command if there is one.
+func CheckForUpdate() (UpdateStatus, error) {
+	if len(files.Config.UpdateCheckCommand) > 0 {
+		return runUpdateCheckCommand()
+	}
+	installed, err := Updater.InstalledVersion()
+	if err != nil {
+		return UpdateStatus{}, err
+	}
+	latest, err := Updater.LatestVersion()
+	if err != nil {
+		return UpdateStatus{Installed: installed}, err
+	}
+	return UpdateStatus{
+		Installed:       installed,
+		Latest:          latest,
+		UpdateAvailable: latest != "" && latest != installed,
+	}, nil
+}
+
+// ==== Update command ========================================================
+
+// commandProvider runs the update_commands (or update_command) from the config, it can't tell which build is installed.
+type commandProvider struct{}
+
+func (commandProvider) Name() string { return "command" }
+
+func (commandProvider) InstalledVersion() (string, error) { return "", nil }
+
+func (commandProvider) LatestVersion() (string, error) { return "", ErrUpdateCheckUnsupported }
+
+func (commandProvider) Update(progress files.Progress) error {
+	steps := files.Config.UpdateCommands
+	if len(steps) == 0 {
+		if files.Config.UpdateCommand == "" {
+			return errors.New("update command not set")
+		}
+		args, err := splitCommand(files.Config.UpdateCommand)
+		if err != nil {
+			return err
+		}
+		steps = [][]string{args}
+	}
+
+	// run each step in order, stopping at the first that fails
+	deadline := time.Now().Add(updateTimeout())
+	for _, args := range steps {
+		progress.Logf("Running %s", strings.Join(args, " "))
+		timeout := time.Duration(0)
+		if files.Config.UpdateTimeoutMins > 0 {
+			if timeout = time.Until(deadline); timeout <= 0 {
+				return fmt.Errorf("update timed out after %d minutes", files.Config.UpdateTimeoutMins)
+			}
+		}
+		if err := runCommand(progress, timeout, args); err != nil {
+			return err
+		}
+	}
+
+	// sleep for 1 second (probably not necessary)
+	time.Sleep(1 * time.Second)
+
+	return nil
+}
+
+// runUpdateCheckCommand runs the update check command, which exits 0 if there's an update and 1 if there isn't.
+func runUpdateCheckCommand() (UpdateStatus, error) {
+	installed, err := Updater.InstalledVersion()
+	if err != nil {
+		return UpdateStatus{}, err
+	}
+	var output bytes.Buffer
+	err = runCommand(&output, updateCheckTimeout, files.Config.UpdateCheckCommand)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return UpdateStatus{Installed: installed, UpdateAvailable: true}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return UpdateStatus{Installed: installed}, nil
+	default:
+		return UpdateStatus{Installed: installed}, fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+}
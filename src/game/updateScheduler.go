@@ -0,0 +1,324 @@
+package game
+
+// game/updateScheduler.go is for checking for updates on a timer and applying them in the next maintenance window,
+// warning players beforehand.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+)
+
+var (
+	UpdateSchedulerStopChan = make(chan struct{})
+	UpdateSchedulerDoneChan = make(chan struct{})
+
+	updateSchedule      UpdateSchedule
+	updateScheduleMutex sync.Mutex
+)
+
+// UpdateSchedule is what the update scheduler is up to.
+type UpdateSchedule struct {
+	Enabled    bool
+	LastCheck  time.Time
+	LastResult string    // what happened last, e.g. "No update available" or an error
+	Pending    bool      // an update was found and is waiting for a maintenance window
+	Build      string    // build the pending update is for, empty if the update check can't tell
+	Held       bool      // a scheduled update to an unknown build was rolled back, it's left until someone updates by hand
+	Paused     bool      // maintenance mode is on, nothing's checked or applied until it's off
+	NextWindow time.Time // start of the current or next maintenance window, zero if updates can happen any time
+}
+
+// GetUpdateSchedule returns the current state of the update scheduler.
+func GetUpdateSchedule() UpdateSchedule {
+	updateScheduleMutex.Lock()
+	defer updateScheduleMutex.Unlock()
+	return updateSchedule
+}
+
+func changeUpdateSchedule(change func(schedule *UpdateSchedule)) {
+	updateScheduleMutex.Lock()
+	defer updateScheduleMutex.Unlock()
+	change(&updateSchedule)
+}
+
+// InitUpdateScheduler starts checking for updates if an interval is configured.
+func InitUpdateScheduler() {
+	if files.Config.UpdateCheckIntervalMins <= 0 {
+		return
+	}
+	windows, err := parseMaintenanceWindows(files.Config.MaintenanceWindows)
+	if err != nil {
+		panic(err)
+	}
+	policy, err := updatePlayerPolicy()
+	if err != nil {
+		panic(err)
+	}
+	changeUpdateSchedule(func(schedule *UpdateSchedule) { schedule.Enabled = true })
+	go updateSchedulerGoroutine(windows, policy)
+}
+
+// updatePlayerPolicy returns update_player_policy, or update_skip_if_players_online as a defer policy if it isn't set.
+func updatePlayerPolicy() (*playerPolicy, error) {
+	config := files.Config.UpdatePlayerPolicy
+	if config.Action == "" && files.Config.UpdateSkipIfPlayersOnline {
+		config.Action = PolicyDefer
+	}
+	return newPlayerPolicy("update_player_policy", config)
+}
+
+func StopUpdateScheduler() {
+	if !GetUpdateSchedule().Enabled {
+		return
+	}
+	UpdateSchedulerStopChan <- struct{}{}
+	<-UpdateSchedulerDoneChan
+}
+
+func updateSchedulerGoroutine(windows []maintenanceWindow, policy *playerPolicy) {
+	interval := time.Duration(files.Config.UpdateCheckIntervalMins) * time.Minute
+	nextCheck := time.Now() // check straight away
+	var skipUntil time.Time // players were on and the policy said to skip, so leave it until the next window
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		paused := MaintenanceActive()
+		changeUpdateSchedule(func(schedule *UpdateSchedule) { schedule.Paused = paused })
+		if !paused && !GetUpdateSchedule().Pending && !now.Before(nextCheck) {
+			checkForScheduledUpdate()
+			nextCheck = now.Add(interval)
+		}
+		inWindow := inMaintenanceWindow(windows, now)
+		if !inWindow {
+			policy.reset() // a deferred update starts its max delay over in the next window
+		}
+		if !paused && GetUpdateSchedule().Pending && inWindow && !now.Before(skipUntil) {
+			skipped, stopped := applyScheduledUpdate(policy)
+			if stopped {
+				UpdateSchedulerDoneChan <- struct{}{}
+				return
+			}
+			if skipped {
+				if skipUntil = maintenanceWindowEnd(windows, now); skipUntil.IsZero() {
+					skipUntil = now.Add(interval)
+				}
+			}
+		}
+		changeUpdateSchedule(func(schedule *UpdateSchedule) {
+			schedule.NextWindow = nextMaintenanceWindow(windows, time.Now())
+		})
+
+		select {
+		case <-ticker.C:
+		case <-UpdateSchedulerStopChan:
+			UpdateSchedulerDoneChan <- struct{}{}
+			return
+		}
+	}
+}
+
+// checkForScheduledUpdate marks an update as pending if there's one, unless it's the build that was last rolled back.
+func checkForScheduledUpdate() {
+	status, err := CheckForUpdate()
+	rolledBack := false
+	if err == nil && status.UpdateAvailable {
+		if status.Latest == "" {
+			rolledBack = GetUpdateSchedule().Held
+		} else {
+			rolledBack, err = files.GameVersionRolledBack(status.Latest)
+		}
+	}
+	changeUpdateSchedule(func(schedule *UpdateSchedule) {
+		schedule.LastCheck = time.Now()
+		switch {
+		case err != nil:
+			schedule.LastResult = "Update check failed: " + err.Error()
+		case rolledBack && status.Latest == "":
+			schedule.LastResult = "The last update was rolled back, update by hand to try again"
+		case rolledBack:
+			schedule.LastResult = fmt.Sprintf("Build %s was rolled back, waiting for a newer build", status.Latest)
+		case status.UpdateAvailable:
+			schedule.Pending = true
+			schedule.Build = status.Latest
+			schedule.LastResult = "Update available"
+			if status.Latest != "" {
+				schedule.LastResult += ", build " + status.Latest
+			}
+		default:
+			schedule.LastResult = "No update available"
+		}
+	})
+	if err != nil {
+		blog.Error(fmt.Sprintf("Scheduled update check failed: %s", err.Error()))
+	} else if status.UpdateAvailable && !rolledBack {
+		blog.Info("Found an update, it'll be applied in the next maintenance window")
+	}
+}
+
+// applyScheduledUpdate runs the update, unless players are online and the policy says to skip it or wait. If they
+// might be online it warns them first. Returns whether it was skipped, and whether the scheduler was stopped while
+// it was warning.
+func applyScheduledUpdate(policy *playerPolicy) (skipped, stopped bool) {
+	result, count := policy.check(time.Now())
+	switch result {
+	case policySkip:
+		setUpdateScheduleResult("Skipped the update, " + playersOnline(count))
+		blog.Info(fmt.Sprintf("Skipped the scheduled update, %s", playersOnline(count)))
+		return true, false
+	case policyWait:
+		setUpdateScheduleResult("Waiting for the server to empty, " + playersOnline(count))
+		return false, false
+	}
+	if count != 0 {
+		warnings := policy.warnings(files.Config.UpdateWarningMins)
+		if warnCountdown(warnings, "restart for an update", setUpdateScheduleResult, UpdateSchedulerStopChan) {
+			return false, true
+		}
+	}
+	// maintenance mode might have been turned on during the countdown, it's still pending for when it's off
+	if MaintenanceActive() {
+		setUpdateScheduleResult("Waiting for maintenance mode to end")
+		return false, false
+	}
+
+	err := RunJob("update", UpdateJob())
+	changeUpdateSchedule(func(schedule *UpdateSchedule) {
+		schedule.Pending = false
+		// builds the check can name are marked as rolled back in the version history instead
+		if errors.Is(err, ErrUpdateRolledBack) && schedule.Build == "" {
+			schedule.Held = true
+		}
+		if err != nil {
+			schedule.LastResult = "Scheduled update failed: " + err.Error()
+		} else {
+			schedule.LastResult = "Updated at " + time.Now().Format("2006-01-02 15:04")
+		}
+	})
+	if err != nil {
+		blog.Error(fmt.Sprintf("Scheduled update failed: %s", err.Error()))
+	} else {
+		blog.Info("Performed scheduled update")
+	}
+	return false, false
+}
+
+// releaseUpdateHold lets scheduled updates go ahead again after someone's updated successfully by hand.
+func releaseUpdateHold() {
+	changeUpdateSchedule(func(schedule *UpdateSchedule) { schedule.Held = false })
+}
+
+func setUpdateScheduleResult(result string) {
+	changeUpdateSchedule(func(schedule *UpdateSchedule) { schedule.LastResult = result })
+}
+
+// ==== Maintenance windows ===================================================
+
+type maintenanceWindow struct {
+	days         map[time.Weekday]bool // every day if empty
+	hour, minute int
+	length       time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseMaintenanceWindows(configured []files.MaintenanceWindow) ([]maintenanceWindow, error) {
+	windows := []maintenanceWindow{}
+	for _, window := range configured {
+		start, err := time.Parse("15:04", window.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window start %q, expected HH:MM", window.Start)
+		}
+		if window.DurationMins <= 0 {
+			return nil, errors.New("maintenance window duration_mins must be more than 0")
+		}
+		parsed := maintenanceWindow{
+			days:   map[time.Weekday]bool{},
+			hour:   start.Hour(),
+			minute: start.Minute(),
+			length: time.Duration(window.DurationMins) * time.Minute,
+		}
+		for _, day := range window.Days {
+			key := strings.ToLower(day)
+			if len(key) > 3 {
+				key = key[:3]
+			}
+			weekday, ok := weekdays[key]
+			if !ok {
+				return nil, fmt.Errorf("invalid maintenance window day %q", day)
+			}
+			parsed.days[weekday] = true
+		}
+		windows = append(windows, parsed)
+	}
+	return windows, nil
+}
+
+// startOn returns when the window opens on the given day, and whether it opens that day at all.
+func (mw maintenanceWindow) startOn(day time.Time) (time.Time, bool) {
+	if len(mw.days) > 0 && !mw.days[day.Weekday()] {
+		return time.Time{}, false
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), mw.hour, mw.minute, 0, 0, day.Location()), true
+}
+
+// inMaintenanceWindow returns true if t is inside one of the windows, or there aren't any windows.
+func inMaintenanceWindow(windows []maintenanceWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		// yesterday's window might run past midnight
+		for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+			start, ok := window.startOn(day)
+			if ok && !t.Before(start) && t.Before(start.Add(window.length)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maintenanceWindowEnd returns when the window t is in closes, the latest if it's in more than one. Zero if it isn't
+// in a window.
+func maintenanceWindowEnd(windows []maintenanceWindow, t time.Time) time.Time {
+	var end time.Time
+	for _, window := range windows {
+		for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+			start, ok := window.startOn(day)
+			if ok && !t.Before(start) && t.Before(start.Add(window.length)) && start.Add(window.length).After(end) {
+				end = start.Add(window.length)
+			}
+		}
+	}
+	return end
+}
+
+// nextMaintenanceWindow returns the start of the window t is in, or the next one to open. Zero if there are no windows.
+func nextMaintenanceWindow(windows []maintenanceWindow, t time.Time) time.Time {
+	var next time.Time
+	for _, window := range windows {
+		for offset := -1; offset <= 7; offset++ {
+			start, ok := window.startOn(t.AddDate(0, 0, offset))
+			if !ok || !t.Before(start.Add(window.length)) {
+				continue
+			}
+			if next.IsZero() || start.Before(next) {
+				next = start
+			}
+			break
+		}
+	}
+	return next
+}
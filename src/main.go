@@ -37,13 +37,28 @@ func startup() {
 	files.LoadConfig()
 	initLogger()
 	files.InitBackupPaths()
+	files.InitShareLinks()
+	files.InitMods()
+	files.InitAccessLists()
+	files.InitReplication()
+	game.InitJobs()
+	game.InitMaintenance()
+	game.InitUpdateProvider()
 	game.InitGameServer()
+	game.InitQuery()
+	game.InitPlayerTracking()
 	game.InitAutoBackup()
+	game.InitUpdateScheduler()
 }
 
 func cleanup() {
+	game.StopUpdateScheduler()
+	game.StopJobs()
 	game.StopAutoBackup()
+	game.StopQuery()
 	game.Process.Stop()
+	game.StopPlayerTracking()
+	files.StopReplication()
 	files.CloseDatabase()
 	blog.SyncFlush(0)
 }
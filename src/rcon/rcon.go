@@ -0,0 +1,168 @@
+// Package rcon is a client for the Source RCON protocol, which Minecraft also uses.
+// See https://developer.valvesoftware.com/wiki/Source_RCON_Protocol
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// packet types, exec and auth response share a value
+const (
+	typeAuth         int32 = 3
+	typeAuthResponse int32 = 2
+	typeExecCommand  int32 = 2
+	typeResponse     int32 = 0
+)
+
+const (
+	headerSize    = 8                 // id and type, the size field isn't counted in the size
+	maxPacketSize = 4096              // biggest packet servers send or accept, including the two null bytes
+	maxBodySize   = maxPacketSize - 2 // room for the two null bytes
+)
+
+var (
+	ErrAuthFailed      = errors.New("rcon: wrong password")
+	ErrCommandTooLong  = errors.New("rcon: command is too long")
+	ErrInvalidResponse = errors.New("rcon: invalid response from server")
+)
+
+// Client is a connection to an RCON server. It's safe to use from multiple goroutines, commands run one at a time.
+type Client struct {
+	conn    net.Conn
+	timeout time.Duration
+	mutex   sync.Mutex
+	nextID  int32
+}
+
+// Dial connects to the server at address ("host:port") and logs in. timeout applies to connecting and to each
+// command after that.
+func Dial(address, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{conn: conn, timeout: timeout, nextID: 1}
+	if err := client.auth(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) auth(password string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	id := c.newID()
+	if err := c.write(id, typeAuth, password); err != nil {
+		return err
+	}
+	// source servers send an empty response before the auth response, so skip anything else
+	for {
+		responseID, responseType, _, err := c.read()
+		if err != nil {
+			return err
+		}
+		if responseType != typeAuthResponse {
+			continue
+		}
+		if responseID == -1 {
+			return ErrAuthFailed
+		}
+		if responseID != id {
+			return ErrInvalidResponse
+		}
+		return nil
+	}
+}
+
+// Execute runs a command and returns the server's response.
+func (c *Client) Execute(command string) (string, error) {
+	if len(command) > maxBodySize {
+		return "", ErrCommandTooLong
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	// long responses are split over several packets with no way to tell which is last, so an empty response packet
+	// is sent straight after the command. Servers answer requests in order, so once it's answered everything for
+	// the command has arrived. Source mirrors it, minecraft replies "Unknown request", either is fine.
+	id, endID := c.newID(), c.newID()
+	if err := c.write(id, typeExecCommand, command); err != nil {
+		return "", err
+	}
+	if err := c.write(endID, typeResponse, ""); err != nil {
+		return "", err
+	}
+
+	var response strings.Builder
+	for {
+		responseID, responseType, body, err := c.read()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case responseID == endID:
+			return response.String(), nil
+		case responseID == id && responseType == typeResponse:
+			response.WriteString(body)
+		default:
+			// left over from an earlier command, e.g. the extra packet source sends after mirroring the end marker
+		}
+	}
+}
+
+func (c *Client) newID() int32 {
+	id := c.nextID
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1 // -1 means auth failed, so stay positive
+	}
+	return id
+}
+
+func (c *Client) write(id, packetType int32, body string) error {
+	var packet bytes.Buffer
+	size := int32(headerSize + len(body) + 2)
+	binary.Write(&packet, binary.LittleEndian, size)
+	binary.Write(&packet, binary.LittleEndian, id)
+	binary.Write(&packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+	_, err := c.conn.Write(packet.Bytes())
+	return err
+}
+
+func (c *Client) read() (id, packetType int32, body string, err error) {
+	var size int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", err
+	}
+	if size < headerSize+2 || size > maxPacketSize+headerSize {
+		return 0, 0, "", fmt.Errorf("%w: packet size %d", ErrInvalidResponse, size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, packet); err != nil {
+		return 0, 0, "", err
+	}
+	id = int32(binary.LittleEndian.Uint32(packet[0:4]))
+	packetType = int32(binary.LittleEndian.Uint32(packet[4:8]))
+	// the body should end with two nulls, but some servers only send one
+	body = strings.TrimRight(string(packet[headerSize:]), "\x00")
+	return id, packetType, body, nil
+}
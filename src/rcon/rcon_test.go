@@ -0,0 +1,193 @@
+package rcon
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPassword = "hunter2"
+
+// fakeServer is a minimal RCON server. "source" mode mirrors empty response packets and sends the extra packet
+// source servers do, "minecraft" mode answers them with "Unknown request" like minecraft.
+type fakeServer struct {
+	listener net.Listener
+	mode     string
+	commands map[string]string // responses by command, "silent" never responds and "quit" hangs up
+}
+
+func startFakeServer(t *testing.T, mode string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{listener: listener, mode: mode, commands: map[string]string{
+		"list": "There are 2 of a max of 20 players online: alice, bob",
+		"long": strings.Repeat("0123456789", 1000),
+	}}
+	t.Cleanup(func() { listener.Close() })
+	go server.serve()
+	return server
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		id, packetType, body, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		switch packetType {
+		case typeAuth:
+			writePacket(conn, id, typeResponse, "")
+			if body != testPassword {
+				id = -1
+			}
+			writePacket(conn, id, typeAuthResponse, "")
+		case typeExecCommand:
+			switch body {
+			case "silent":
+				io.Copy(io.Discard, conn)
+				return
+			case "quit":
+				return
+			}
+			response, ok := s.commands[body]
+			if !ok {
+				response = "Unknown command: " + body
+			}
+			// split like servers do when the response doesn't fit in one packet
+			for len(response) > maxBodySize {
+				writePacket(conn, id, typeResponse, response[:maxBodySize])
+				response = response[maxBodySize:]
+			}
+			writePacket(conn, id, typeResponse, response)
+		case typeResponse:
+			if s.mode == "minecraft" {
+				writePacket(conn, id, typeResponse, "Unknown request 0")
+			} else {
+				writePacket(conn, id, typeResponse, "")
+				writePacket(conn, id, typeResponse, "\x00\x00\x00\x01\x00\x00\x00\x00")
+			}
+		}
+	}
+}
+
+func readPacket(r io.Reader) (id, packetType int32, body string, err error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", err
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, 0, "", err
+	}
+	id = int32(binary.LittleEndian.Uint32(packet[0:4]))
+	packetType = int32(binary.LittleEndian.Uint32(packet[4:8]))
+	return id, packetType, string(packet[8 : len(packet)-2]), nil
+}
+
+func writePacket(w io.Writer, id, packetType int32, body string) {
+	binary.Write(w, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(w, binary.LittleEndian, id)
+	binary.Write(w, binary.LittleEndian, packetType)
+	w.Write(append([]byte(body), 0, 0))
+}
+
+func TestExecute(t *testing.T) {
+	for _, mode := range []string{"source", "minecraft"} {
+		t.Run(mode, func(t *testing.T) {
+			server := startFakeServer(t, mode)
+			client, err := Dial(server.address(), testPassword, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// run a few in a row to make sure leftover packets from one don't end up in the next
+			for _, command := range []string{"list", "long", "list", "nope"} {
+				response, err := client.Execute(command)
+				if err != nil {
+					t.Fatalf("%s: %s", command, err)
+				}
+				want, ok := server.commands[command]
+				if !ok {
+					want = "Unknown command: " + command
+				}
+				if response != want {
+					t.Fatalf("%s: got %d bytes %.40q, want %d bytes %.40q", command, len(response), response, len(want), want)
+				}
+			}
+		})
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	server := startFakeServer(t, "source")
+	_, err := Dial(server.address(), "wrong", time.Second)
+	if err != ErrAuthFailed {
+		t.Fatalf("got %v, want ErrAuthFailed", err)
+	}
+}
+
+func TestCommandTooLong(t *testing.T) {
+	server := startFakeServer(t, "minecraft")
+	client, err := Dial(server.address(), testPassword, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Execute(strings.Repeat("a", maxBodySize+1)); err != ErrCommandTooLong {
+		t.Fatalf("got %v, want ErrCommandTooLong", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := startFakeServer(t, "minecraft")
+	client, err := Dial(server.address(), testPassword, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	_, err = client.Execute("silent")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("took %s to time out", elapsed)
+	}
+}
+
+func TestServerClosed(t *testing.T) {
+	server := startFakeServer(t, "source")
+	client, err := Dial(server.address(), testPassword, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Execute("quit"); err == nil {
+		t.Fatal("expected an error when the server hung up")
+	}
+}
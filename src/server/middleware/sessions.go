@@ -22,6 +22,7 @@ var (
 		"/login",
 		"/denied",
 		"/public/",
+		"/share/", // share links check their own signature
 	}
 	ErrSessionNotFoundOrExpired = errors.New("session not found")
 )
@@ -37,6 +37,13 @@ func NewRouter() *chi.Mux {
 	// Define routes
 	routes.RegisterLoginRoutes(r, Instance.UsingTLS)
 	routes.RegisterDashboardRoutes(r)
+	routes.RegisterBackupContentRoutes(r)
+	routes.RegisterJobRoutes(r)
+	routes.RegisterShareRoutes(r)
+	routes.RegisterModRoutes(r)
+	routes.RegisterFileManagerRoutes(r)
+	routes.RegisterPlayerRoutes(r)
+	routes.RegisterAccessListRoutes(r)
 	r.Get("/denied", DeniedAccessHandler)
 
 	// Serve static files
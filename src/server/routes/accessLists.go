@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"tsm/src/files"
+	"tsm/src/game"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// AccessListResponse is one access list and who's on it.
+type AccessListResponse struct {
+	Name    string
+	Players []string
+	Live    bool   // changes go through the console since the server's running
+	Error   string // why the file couldn't be read
+}
+
+// AccessListsResponse is every access list after a change, and what the console said if the change went through it.
+type AccessListsResponse struct {
+	Lists    []AccessListResponse
+	Response string
+}
+
+// writeAccessListsResponse sends the current lists, or the error from the change that was just made.
+func writeAccessListsResponse(w http.ResponseWriter, response string, err error) {
+	if err != nil {
+		switch err {
+		case files.ErrAccessListNotFound, files.ErrPlayerNotListed:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case files.ErrPlayerListed:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case files.ErrInvalidPlayerName:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	lists := []AccessListResponse{}
+	for _, list := range files.Config.AccessLists {
+		listResponse := AccessListResponse{Name: list.Name, Players: []string{}}
+		listResponse.Live = game.Process.GetRunning() && (list.AddCommand != "" || list.RemoveCommand != "")
+		if players, err := files.ReadAccessList(list); err != nil {
+			listResponse.Error = err.Error()
+		} else {
+			listResponse.Players = players
+		}
+		lists = append(lists, listResponse)
+	}
+	writeJSON(w, AccessListsResponse{Lists: lists, Response: response})
+}
+
+func RegisterAccessListRoutes(r *chi.Mux) {
+	r.Get("/access-lists", func(w http.ResponseWriter, r *http.Request) {
+		writeAccessListsResponse(w, "", nil)
+	})
+
+	// adds ?player to a list
+	r.Post("/access-lists/{name}/add", func(w http.ResponseWriter, r *http.Request) {
+		list, err := files.GetAccessList(chi.URLParam(r, "name"))
+		if err != nil {
+			writeAccessListsResponse(w, "", err)
+			return
+		}
+		player := r.FormValue("player")
+		response, err := game.AddToAccessList(list, player)
+		if err == nil {
+			blog.Info(fmt.Sprintf("Added %s to %s", player, list.Name))
+		}
+		writeAccessListsResponse(w, response, err)
+	})
+
+	// removes ?player from a list
+	r.Post("/access-lists/{name}/remove", func(w http.ResponseWriter, r *http.Request) {
+		list, err := files.GetAccessList(chi.URLParam(r, "name"))
+		if err != nil {
+			writeAccessListsResponse(w, "", err)
+			return
+		}
+		player := r.FormValue("player")
+		response, err := game.RemoveFromAccessList(list, player)
+		if err == nil {
+			blog.Info(fmt.Sprintf("Removed %s from %s", player, list.Name))
+		}
+		writeAccessListsResponse(w, response, err)
+	})
+}
@@ -0,0 +1,165 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"tsm/src/files"
+	"tsm/src/game"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// getBackupFromQuery gets the backup referenced by the backupId query param,
+// writing an error response and returning false if it can't.
+func getBackupFromQuery(w http.ResponseWriter, r *http.Request) (files.Backup, bool) {
+	return getBackupFromParam(w, r, "backupId")
+}
+
+// getBackupFromParam is getBackupFromQuery for an arbitrarily named query param.
+func getBackupFromParam(w http.ResponseWriter, r *http.Request, param string) (files.Backup, bool) {
+	backupId := r.URL.Query().Get(param)
+	if backupId == "" {
+		blog.Error("No backup ID provided")
+		http.Error(w, "No backup ID provided", http.StatusBadRequest)
+		return files.Backup{}, false
+	}
+
+	backup, err := files.GetBackup(backupId)
+	if err != nil {
+		if err == files.ErrBackupNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return backup, false
+		}
+		blog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return backup, false
+	}
+	return backup, true
+}
+
+func RegisterBackupContentRoutes(r *chi.Mux) {
+	// lists the entries inside a backup
+	r.Get("/backup/entries", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+
+		entries, err := files.ListBackupEntries(backup)
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, entries)
+	})
+
+	// checks the backup's checksum and reads through the whole archive
+	r.Post("/backup/verify", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+
+		if err := files.VerifyBackup(backup); err != nil {
+			blog.Warn(fmt.Sprintf("Backup %s failed verification: %s", backup.Name, err.Error()))
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		blog.Info(fmt.Sprintf("Backup %s passed verification", backup.Name))
+	})
+
+	// lists the upload state of the backup on each replication target
+	r.Get("/backup/replicas", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+
+		replicas, err := files.GetBackupReplicas(backup)
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, replicas)
+	})
+
+	// queues the backup for upload to every target it hasn't reached yet
+	r.Post("/backup/replicate", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+
+		if err := files.RetryReplication(backup); err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// compares two backups (from and to query params), or a backup with the live save if to is "live"
+	r.Get("/backup/diff", func(w http.ResponseWriter, r *http.Request) {
+		fromBackup, ok := getBackupFromParam(w, r, "from")
+		if !ok {
+			return
+		}
+
+		var diff files.BackupDiff
+		var err error
+		if r.URL.Query().Get("to") == "live" {
+			diff, err = files.DiffBackupWithLive(fromBackup)
+		} else {
+			toBackup, ok := getBackupFromParam(w, r, "to")
+			if !ok {
+				return
+			}
+			diff, err = files.DiffBackups(fromBackup, toBackup)
+		}
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, diff)
+	})
+
+	// downloads a single entry (file, or zipped directory) from a backup
+	r.Get("/backup/entry", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+		entryPath := r.URL.Query().Get("path")
+		blog.Debug(fmt.Sprintf("Entry path: %s", entryPath))
+
+		if err := files.SendBackupEntryToClient(w, backup, entryPath); err != nil {
+			blog.Error(err.Error())
+			if err == files.ErrEntryNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		blog.Info("Successfully sent backup entry to client")
+	})
+
+	// restores a single entry (and everything under it) from a backup into the live save
+	r.Post("/backup/entry/restore", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+		entryPath := r.URL.Query().Get("path")
+		if entryPath == "" {
+			http.Error(w, "No entry path provided", http.StatusBadRequest)
+			return
+		}
+
+		startJob(w, "restore", game.RestoreEntryJob(backup, entryPath))
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"tsm/src/files"
 	"tsm/src/game"
@@ -13,8 +15,40 @@ import (
 )
 
 type DashboardPageData struct {
-	Title   string
-	Backups []files.Backup
+	Title              string
+	Backups            []files.Backup
+	ReplicationTargets []string
+	Disks              []files.DiskUsage
+	GameVersion        files.GameVersion
+	UpdateSchedule     game.UpdateSchedule
+	ModsEnabled        bool
+	FilesEnabled       bool
+	QueryEnabled       bool
+	PlayersEnabled     bool
+	AccessListsEnabled bool
+	Maintenance        files.MaintenanceMode
+}
+
+// ServerStatusResponse is the game server's state for GET /status.
+type ServerStatusResponse struct {
+	Running     bool
+	Status      string
+	Query       game.ServerStatus
+	Maintenance files.MaintenanceMode
+}
+
+// setMaintenanceMode turns maintenance mode on or off, then sends it and what the console said.
+func setMaintenanceMode(w http.ResponseWriter, enabled bool, reason string) {
+	response, err := game.SetMaintenanceMode(enabled, reason)
+	if err != nil {
+		blog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		Maintenance files.MaintenanceMode
+		Response    string
+	}{game.GetMaintenanceMode(), response})
 }
 
 func RegisterDashboardRoutes(r *chi.Mux) {
@@ -28,9 +62,29 @@ func RegisterDashboardRoutes(r *chi.Mux) {
 		}
 		blog.Debug(fmt.Sprintf("Backups: %v", backups))
 
+		// disk usage and the installed build aren't worth failing the page over
+		disks, err := files.GetDiskUsages()
+		if err != nil {
+			blog.Error(err.Error())
+		}
+		gameVersion, err := files.GetCurrentGameVersion()
+		if err != nil {
+			blog.Error(err.Error())
+		}
+
 		pageData := DashboardPageData{
-			Title:   files.Config.DashboardTitle,
-			Backups: backups,
+			Title:              files.Config.DashboardTitle,
+			Backups:            backups,
+			ReplicationTargets: files.ReplicationTargetNames(),
+			Disks:              disks,
+			GameVersion:        gameVersion,
+			UpdateSchedule:     game.GetUpdateSchedule(),
+			ModsEnabled:        files.Config.ModsDir != "",
+			FilesEnabled:       files.Config.FileManagerRoot != "",
+			QueryEnabled:       game.QueryEnabled(),
+			PlayersEnabled:     game.PlayerTrackingEnabled(),
+			AccessListsEnabled: len(files.Config.AccessLists) > 0,
+			Maintenance:        game.GetMaintenanceMode(),
 		}
 
 		// get the dashboard template path
@@ -86,112 +140,147 @@ func RegisterDashboardRoutes(r *chi.Mux) {
 		comment := r.FormValue("comment")
 		blog.Debug(fmt.Sprintf("Comment: %s", comment))
 
-		// lock the game mutex
-		game.Process.Mutex.Lock()
-		defer game.Process.Mutex.Unlock()
-
-		// stop the server
-		if err := game.Process.Stop(); err != nil {
-			blog.Error(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// back up in the background, the save unless the install directory was asked for
+		switch r.FormValue("kind") {
+		case "", files.BackupKindSave:
+			startJob(w, "backup", game.BackupJob(comment))
+		case files.BackupKindInstall:
+			startJob(w, "backup", game.InstallBackupJob(comment))
+		default:
+			http.Error(w, "Invalid backup kind", http.StatusBadRequest)
 		}
+	})
 
-		// create the backup
-		if err := files.CreateBackup(comment); err != nil {
-			blog.Error(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	r.Post("/update", func(w http.ResponseWriter, r *http.Request) {
+		// update in the background
+		startJob(w, "update", game.UpdateJob())
+	})
 
-		// start the server again
-		if err := game.Process.Start(); err != nil {
+	// asks the update provider if there's a newer build than the installed one
+	r.Get("/update/check", func(w http.ResponseWriter, r *http.Request) {
+		status, err := game.CheckForUpdate()
+		if err != nil {
+			if err == game.ErrUpdateCheckUnsupported {
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+				return
+			}
 			blog.Error(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
+		writeJSON(w, status)
 	})
 
-	r.Post("/update", func(w http.ResponseWriter, r *http.Request) {
-		blog.Debug("Start of update handler")
-
-		// lock the game mutex
-		game.Process.Mutex.Lock()
-		defer game.Process.Mutex.Unlock()
-		blog.Debug("Locked game mutex")
+	// what the update scheduler is up to
+	r.Get("/update/schedule", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, game.GetUpdateSchedule())
+	})
 
-		// stop the server
-		if err := game.Process.Stop(); err != nil {
-			blog.Error(fmt.Sprintf("Failed to stop game server: %s", err.Error()))
+	// history of installed builds, newest first
+	r.Get("/versions", func(w http.ResponseWriter, r *http.Request) {
+		versions, err := files.GetGameVersions(50)
+		if err != nil {
+			blog.Error(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		blog.Debug("Stopped game server")
+		writeJSON(w, versions)
+	})
 
-		// update the server
-		var err error
-		if err = game.Update(); err != nil {
-			blog.Error(fmt.Sprintf("Failed to update game server: %s", err.Error()))
-		}
+	// whether the server's running, what it said the last time it was queried and whether it's in maintenance mode
+	r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, ServerStatusResponse{
+			Running:     game.Process.GetRunning(),
+			Status:      game.Process.GetStatus(),
+			Query:       game.GetServerStatus(),
+			Maintenance: game.GetMaintenanceMode(),
+		})
+	})
 
-		// start the server again
-		if err := game.Process.Start(); err != nil {
-			blog.Error(fmt.Sprintf("Failed to start game server: %s", err.Error()))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		blog.Debug("Started game server")
+	// turns maintenance mode on with an optional reason, or off
+	r.Post("/maintenance/enter", func(w http.ResponseWriter, r *http.Request) {
+		setMaintenanceMode(w, true, r.FormValue("reason"))
+	})
+	r.Post("/maintenance/exit", func(w http.ResponseWriter, r *http.Request) {
+		setMaintenanceMode(w, false, "")
+	})
 
-		// if there was an error updating the server, return it
+	// player counts over the last ?hours (24 by default)
+	r.Get("/status/history", func(w http.ResponseWriter, r *http.Request) {
+		hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+		if err != nil || hours <= 0 {
+			hours = 24
+		}
+		history, err := files.GetPlayerCountHistory(time.Now().Add(-time.Duration(hours) * time.Hour))
 		if err != nil {
+			blog.Error(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		writeJSON(w, history)
 	})
 
-	r.Get("/download", func(w http.ResponseWriter, r *http.Request) {
-		// get the id of the backup to download
-		backupId := r.URL.Query().Get("backupId")
-		blog.Debug(fmt.Sprintf("Backup ID: %s", backupId))
-		if backupId == "" {
-			blog.Error("No backup ID provided")
-			http.Error(w, "No backup ID provided", http.StatusBadRequest)
+	// runs a command in the server's console, the response is only sent back over rcon
+	r.Post("/console", func(w http.ResponseWriter, r *http.Request) {
+		command := r.FormValue("command")
+		if command == "" {
+			http.Error(w, "No command provided", http.StatusBadRequest)
 			return
 		}
-
-		// get the file path of the backup
-		filePath, err := files.GetBackupFilePath(backupId)
+		blog.Info(fmt.Sprintf("Running console command: %s", command))
+		response, err := game.Process.Console(command)
 		if err != nil {
 			blog.Error(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		writeJSON(w, struct {
+			Response string
+			Rcon     bool
+		}{response, game.RconEnabled()})
+	})
 
-		// send the file to the client
-		if err := files.SendFileToClient(w, filePath); err != nil {
+	// the built in game presets and the config each one fills in
+	r.Get("/presets", func(w http.ResponseWriter, r *http.Request) {
+		presets, err := files.GetPresets()
+		if err != nil {
 			blog.Error(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		blog.Info("Successfully sent file to client")
+		writeJSON(w, presets)
 	})
 
-	r.Post("/restore", func(w http.ResponseWriter, r *http.Request) {
-		// get the id of the backup to restore
-		backupId := r.URL.Query().Get("backupId")
-		if backupId == "" {
-			blog.Error("No backup ID provided")
-			http.Error(w, "No backup ID provided", http.StatusBadRequest)
+	r.Get("/download", func(w http.ResponseWriter, r *http.Request) {
+		// get the backup to download
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+		blog.Debug(fmt.Sprintf("Backup ID: %d", backup.ID))
+
+		// encrypted backups are sent as is unless decryption is requested
+		if backup.Encrypted && r.URL.Query().Get("decrypt") == "true" {
+			if err := files.SendDecryptedBackupToClient(w, backup); err != nil {
+				blog.Error(err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			blog.Info("Successfully sent decrypted file to client")
 			return
 		}
 
-		// get the file path of the backup
-		filePath, err := files.GetBackupFilePath(backupId)
-		if err != nil {
+		// send the file to the client
+		if err := files.SendBackupToClient(w, r, backup); err != nil {
 			blog.Error(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		blog.Info("Successfully sent file to client")
+	})
 
+	// streams an archive of the live save without registering a backup, the server is stopped until it's done
+	r.Get("/save/download", func(w http.ResponseWriter, r *http.Request) {
 		// lock the game mutex
 		game.Process.Mutex.Lock()
 		defer game.Process.Mutex.Unlock()
@@ -203,18 +292,31 @@ func RegisterDashboardRoutes(r *chi.Mux) {
 			return
 		}
 
-		// restore the backup
-		if err := files.RestoreBackup(filePath); err != nil {
-			blog.Error(err.Error())
+		// start it again once the stream finishes, or the client goes away and writes start failing
+		defer func() {
+			if err := game.Process.Start(); err != nil {
+				blog.Error(err.Error())
+			}
+		}()
+
+		// if the stream already started the error won't reach the client, but the download will be cut short
+		if err := files.SendLiveSaveToClient(w, r.URL.Query().Get("format")); err != nil {
+			blog.Error(fmt.Sprintf("Failed to stream live save: %s", err.Error()))
+			w.Header().Del("Content-Disposition")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		blog.Info("Successfully streamed live save to client")
+	})
 
-		// start the server again
-		if err := game.Process.Start(); err != nil {
-			blog.Error(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	r.Post("/restore", func(w http.ResponseWriter, r *http.Request) {
+		// get the backup to restore
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
 			return
 		}
+
+		// restore in the background, from a replication target if one is given
+		startJob(w, "restore", game.RestoreJob(backup, r.URL.Query().Get("target")))
 	})
 }
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"tsm/src/files"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// writeFileManagerError sends the right status for an error from the file manager.
+func writeFileManagerError(w http.ResponseWriter, err error) {
+	switch err {
+	case files.ErrManagedNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case files.ErrOutsideRoot:
+		blog.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case files.ErrFileManagerDisabled, files.ErrManagedExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case files.ErrNotEditable:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		blog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func RegisterFileManagerRoutes(r *chi.Mux) {
+	// lists a directory
+	r.Get("/files", func(w http.ResponseWriter, r *http.Request) {
+		list, err := files.ListManagedDir(r.URL.Query().Get("path"))
+		if err != nil {
+			writeFileManagerError(w, err)
+			return
+		}
+		writeJSON(w, list)
+	})
+
+	// gets a text file for the editor
+	r.Get("/files/read", func(w http.ResponseWriter, r *http.Request) {
+		data, err := files.ReadManagedFile(r.URL.Query().Get("path"))
+		if err != nil {
+			writeFileManagerError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(data)
+	})
+
+	// saves the editor's text, the body is the new contents
+	r.Post("/files/write", func(w http.ResponseWriter, r *http.Request) {
+		filePath := r.URL.Query().Get("path")
+		if err := files.WriteManagedFile(filePath, r.Body); err != nil {
+			writeFileManagerError(w, err)
+			return
+		}
+		blog.Info(fmt.Sprintf("Saved %s in the file manager", filePath))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// uploads files into the directory in the path param, streamed so big files are fine
+	r.Post("/files/upload", func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+			return
+		}
+		uploaded := 0
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() != "file" || part.FileName() == "" {
+				continue
+			}
+			filePath, err := files.UploadManagedFile(r.URL.Query().Get("path"), part.FileName(), part)
+			if err != nil {
+				writeFileManagerError(w, err)
+				return
+			}
+			blog.Info(fmt.Sprintf("Uploaded %s in the file manager", filePath))
+			uploaded++
+		}
+		if uploaded == 0 {
+			http.Error(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r.Get("/files/download", func(w http.ResponseWriter, r *http.Request) {
+		filePath, err := files.GetManagedFilePath(r.URL.Query().Get("path"))
+		if err != nil {
+			writeFileManagerError(w, err)
+			return
+		}
+		if err := files.SendFileToClient(w, r, filePath); err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.Post("/files/rename", func(w http.ResponseWriter, r *http.Request) {
+		from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		if err := files.RenameManagedFile(from, to); err != nil {
+			writeFileManagerError(w, err)
+			return
+		}
+		blog.Info(fmt.Sprintf("Renamed %s to %s in the file manager", from, to))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r.Post("/files/delete", func(w http.ResponseWriter, r *http.Request) {
+		filePath := r.URL.Query().Get("path")
+		if err := files.DeleteManagedFile(filePath); err != nil {
+			writeFileManagerError(w, err)
+			return
+		}
+		blog.Info(fmt.Sprintf("Deleted %s in the file manager", filePath))
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"tsm/src/files"
+	"tsm/src/game"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// startJob starts a job in the background and responds with it, the client follows it with /jobs/{id}.
+func startJob(w http.ResponseWriter, jobType string, fn game.JobFunc) {
+	job, err := game.StartJob(jobType, fn)
+	if err != nil {
+		blog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		blog.Error(err.Error())
+	}
+}
+
+// getJobFromParam gets the job referenced by the id url param,
+// writing an error response and returning false if it can't.
+func getJobFromParam(w http.ResponseWriter, r *http.Request) (files.Job, bool) {
+	ID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return files.Job{}, false
+	}
+	job, err := game.GetJob(uint(ID))
+	if err != nil {
+		if err == files.ErrJobNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return job, false
+		}
+		blog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return job, false
+	}
+	return job, true
+}
+
+func RegisterJobRoutes(r *chi.Mux) {
+	// lists recent jobs, newest first
+	r.Get("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := files.GetRecentJobs(50)
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, jobs)
+	})
+
+	// current state of a job, for polling
+	r.Get("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := getJobFromParam(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, job)
+	})
+
+	// streams the job's state as server sent events until it finishes
+	r.Get("/jobs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := getJobFromParam(w, r)
+		if !ok {
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		send := func(job files.Job) bool {
+			data, err := json.Marshal(job)
+			if err != nil {
+				blog.Error(err.Error())
+				return false
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return false
+			}
+			flusher.Flush()
+			return true
+		}
+
+		// subscribe before sending the first state so no change is missed in between
+		changed, unsubscribe, running := game.SubscribeJob(job.ID)
+		if !running {
+			if job, err := game.GetJob(job.ID); err == nil {
+				send(job)
+			}
+			return
+		}
+		defer unsubscribe()
+
+		for {
+			job, err := game.GetJob(job.ID)
+			if err != nil {
+				blog.Error(err.Error())
+				return
+			}
+			if !send(job) || game.IsJobDone(job) {
+				return
+			}
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
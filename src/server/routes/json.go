@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// Helper function to write a value as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		blog.Error(err.Error())
+	}
+}
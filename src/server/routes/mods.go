@@ -0,0 +1,137 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"tsm/src/files"
+	"tsm/src/game"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// ModsResponse is the mod library after a change, and whether the server needs a restart to pick it up.
+type ModsResponse struct {
+	Mods          []files.Mod
+	RestartNeeded bool
+}
+
+// writeModsResponse sends the current mod list, or the error from the change that was just made.
+func writeModsResponse(w http.ResponseWriter, err error) {
+	if err != nil {
+		switch err {
+		case files.ErrModNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case files.ErrModsDisabled, files.ErrModExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	mods, err := files.GetMods()
+	if err != nil {
+		blog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, ModsResponse{Mods: mods, RestartNeeded: game.Process.GetRunning()})
+}
+
+// getModID parses the {id} URL param, writing an error if it's invalid.
+func getModID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	ID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid mod ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(ID), true
+}
+
+func RegisterModRoutes(r *chi.Mux) {
+	// lists the mod library in load order
+	r.Get("/mods", func(w http.ResponseWriter, r *http.Request) {
+		mods, err := files.GetMods()
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, mods)
+	})
+
+	// adds the uploaded file to the library, streamed rather than buffered since mods can be big
+	r.Post("/mods", func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				http.Error(w, "No file uploaded", http.StatusBadRequest)
+				return
+			}
+			if part.FormName() != "file" || part.FileName() == "" {
+				continue
+			}
+			mod, err := files.AddMod(part.FileName(), part)
+			if err == nil {
+				blog.Info(fmt.Sprintf("Added mod %s", mod.FileName))
+			}
+			writeModsResponse(w, err)
+			return
+		}
+	})
+
+	r.Post("/mods/{id}/enable", func(w http.ResponseWriter, r *http.Request) {
+		ID, ok := getModID(w, r)
+		if !ok {
+			return
+		}
+		mod, err := files.EnableMod(ID)
+		if err == nil {
+			blog.Info(fmt.Sprintf("Enabled mod %s", mod.FileName))
+		}
+		writeModsResponse(w, err)
+	})
+
+	r.Post("/mods/{id}/disable", func(w http.ResponseWriter, r *http.Request) {
+		ID, ok := getModID(w, r)
+		if !ok {
+			return
+		}
+		mod, err := files.DisableMod(ID)
+		if err == nil {
+			blog.Info(fmt.Sprintf("Disabled mod %s", mod.FileName))
+		}
+		writeModsResponse(w, err)
+	})
+
+	r.Post("/mods/{id}/delete", func(w http.ResponseWriter, r *http.Request) {
+		ID, ok := getModID(w, r)
+		if !ok {
+			return
+		}
+		err := files.DeleteMod(ID)
+		if err == nil {
+			blog.Info(fmt.Sprintf("Deleted mod %d", ID))
+		}
+		writeModsResponse(w, err)
+	})
+
+	// sets the load order, the body is a JSON array of mod IDs first to last
+	r.Post("/mods/order", func(w http.ResponseWriter, r *http.Request) {
+		var IDs []uint
+		if err := json.NewDecoder(r.Body).Decode(&IDs); err != nil {
+			http.Error(w, "Invalid mod order", http.StatusBadRequest)
+			return
+		}
+		writeModsResponse(w, files.SetModOrder(IDs))
+	})
+}
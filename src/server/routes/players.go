@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"tsm/src/files"
+	"tsm/src/game"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxPlayerSessions = 500
+
+// parseSessionTime reads a time from the sessions query, either RFC3339 or what a datetime-local input sends.
+func parseSessionTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
+
+func RegisterPlayerRoutes(r *chi.Mux) {
+	// everyone online right now, from the join and leave lines in the server's output
+	r.Get("/players", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, game.GetOnlinePlayers())
+	})
+
+	// sessions between ?from and ?to (the last 24 hours by default), optionally for one ?name
+	r.Get("/players/sessions", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		from, err := parseSessionTime(r.URL.Query().Get("from"), now.Add(-24*time.Hour))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseSessionTime(r.URL.Query().Get("to"), now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sessions, err := files.GetPlayerSessions(from, to, r.URL.Query().Get("name"), maxPlayerSessions)
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, sessions)
+	})
+}
@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tsm/src/files"
+	"tsm/src/server/middleware"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// ShareLinkResponse is a share link along with the path to give out.
+type ShareLinkResponse struct {
+	files.ShareLink
+	URL string
+}
+
+func newShareLinkResponse(link files.ShareLink) ShareLinkResponse {
+	return ShareLinkResponse{ShareLink: link, URL: "/share/" + link.Token()}
+}
+
+var errUnsupportedRange = errors.New("only a single range from a byte onwards is supported")
+
+// shareRangeStart returns the byte a share download starts from, 0 without a Range header. Only one "bytes=N-" or
+// "bytes=N-M" range is allowed, several ranges or one from the end could piece the file together without it ever
+// counting as a download.
+func shareRangeStart(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, errUnsupportedRange
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if !ok || err != nil || start < 0 {
+		return 0, errUnsupportedRange
+	}
+	if last != "" {
+		if end, err := strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, errUnsupportedRange
+		}
+	}
+	return start, nil
+}
+
+// sentToEnd returns true if a share download that went without error reached the end of the file. A partial response
+// only does if its Content-Range runs to the last byte.
+func sentToEnd(w http.ResponseWriter, r *http.Request) bool {
+	if r.Context().Err() != nil {
+		return false // the client went away part way through
+	}
+	contentRange := w.Header().Get("Content-Range")
+	if contentRange == "" {
+		return true
+	}
+	var start, end, size int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return false
+	}
+	return end+1 == size
+}
+
+// remoteIP returns the request's IP without its port.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func RegisterShareRoutes(r *chi.Mux) {
+	// public, downloads the backup a share link points to
+	r.Get("/share/{token}", func(w http.ResponseWriter, r *http.Request) {
+		start, rangeErr := shareRangeStart(r.Header.Get("Range"))
+		ip, resumeIP := remoteIP(r), ""
+		if start > 0 {
+			resumeIP = ip
+		}
+		link, backup, resume, err := files.ResolveShareToken(chi.URLParam(r, "token"), resumeIP)
+		if err != nil {
+			switch err {
+			case files.ErrInvalidShareToken:
+				// forged or mangled token, treat it like a bad password
+				blog.Warn(fmt.Sprintf("Invalid share link used by %s", r.RemoteAddr))
+				if err := middleware.AddRateLimitedIp(r.RemoteAddr); err != nil {
+					blog.Error(err.Error())
+				}
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case files.ErrShareLinkExpired:
+				http.Error(w, err.Error(), http.StatusGone)
+			case files.ErrBackupNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				blog.Error(err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if rangeErr != nil {
+			http.Error(w, rangeErr.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		// resuming an unfinished download from the same IP doesn't use up another one
+		if !resume {
+			if err := files.CountShareDownload(link, ip); err != nil {
+				if err == files.ErrShareLinkExpired {
+					http.Error(w, err.Error(), http.StatusGone)
+				} else {
+					blog.Error(err.Error())
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			blog.Info(fmt.Sprintf("Share link %d used by %s to download backup %s", link.ID, r.RemoteAddr, backup.Name))
+		}
+
+		// whoever has the link won't have the key, so encrypted backups are always decrypted
+		if backup.Encrypted {
+			err = files.SendDecryptedBackupToClient(w, backup)
+		} else {
+			err = files.SendBackupToClient(w, r, backup)
+		}
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sentToEnd(w, r) {
+			files.FinishShareDownload(link, ip)
+		}
+	})
+
+	// lists the share links that can still be used
+	r.Get("/shares", func(w http.ResponseWriter, r *http.Request) {
+		links, err := files.GetActiveShareLinks()
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response := []ShareLinkResponse{}
+		for _, link := range links {
+			response = append(response, newShareLinkResponse(link))
+		}
+		writeJSON(w, response)
+	})
+
+	// creates a share link for a backup, ?backupId=1&hours=24&maxDownloads=3
+	r.Post("/shares", func(w http.ResponseWriter, r *http.Request) {
+		backup, ok := getBackupFromQuery(w, r)
+		if !ok {
+			return
+		}
+		hours, err := strconv.ParseFloat(r.URL.Query().Get("hours"), 64)
+		if err != nil || hours <= 0 {
+			http.Error(w, "Invalid number of hours", http.StatusBadRequest)
+			return
+		}
+		maxDownloads := 0
+		if value := r.URL.Query().Get("maxDownloads"); value != "" {
+			if maxDownloads, err = strconv.Atoi(value); err != nil || maxDownloads < 0 {
+				http.Error(w, "Invalid max downloads", http.StatusBadRequest)
+				return
+			}
+		}
+
+		link, err := files.CreateShareLink(backup, time.Duration(hours*float64(time.Hour)), maxDownloads)
+		if err != nil {
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		blog.Info(fmt.Sprintf("Created share link %d for backup %s", link.ID, backup.Name))
+		writeJSON(w, newShareLinkResponse(link))
+	})
+
+	// stops a share link from working
+	r.Post("/shares/{id}/revoke", func(w http.ResponseWriter, r *http.Request) {
+		ID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid share link ID", http.StatusBadRequest)
+			return
+		}
+		if err := files.RevokeShareLink(uint(ID)); err != nil {
+			if err == files.ErrShareLinkNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			blog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		blog.Info(fmt.Sprintf("Revoked share link %d", ID))
+	})
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/src/files"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// setupShareTest runs in a temp dir with a fresh database, and shares a 100 byte backup with the given download limit.
+func setupShareTest(t *testing.T, maxDownloads int) (*chi.Mux, string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	files.InitDatabase()
+	files.InitShareLinks()
+	t.Cleanup(func() {
+		files.CloseDatabase()
+		os.Chdir(wd)
+	})
+
+	path := filepath.Join(dir, "backup.zip")
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	backup := files.Backup{Path: path, Name: "backup", Kind: files.BackupKindSave, Size: int64(len(data))}
+	if err := files.DB.Create(&backup).Error; err != nil {
+		t.Fatal(err)
+	}
+	link, err := files.CreateShareLink(backup, time.Hour, maxDownloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	RegisterShareRoutes(r)
+	return r, "/share/" + link.Token()
+}
+
+// download requests the share link from ip, with an optional Range header. Link IDs start over with each test's
+// database, so each test uses its own IP to keep clear of the others' unfinished downloads.
+func download(r http.Handler, url, ip, rangeHeader string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.RemoteAddr = ip + ":50000"
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	r, url := setupShareTest(t, 1)
+	if rec := download(r, url, "192.0.2.1", ""); rec.Code != http.StatusOK || rec.Body.Len() != 100 {
+		t.Fatalf("first download got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	// the download finished, so there's nothing to resume
+	if rec := download(r, url, "192.0.2.1", "bytes=1-"); rec.Code != http.StatusGone {
+		t.Fatalf("range request after the only download got %d, want 410", rec.Code)
+	}
+	if rec := download(r, url, "192.0.2.1", ""); rec.Code != http.StatusGone {
+		t.Fatalf("second download got %d, want 410", rec.Code)
+	}
+}
+
+func TestShareDownloadResume(t *testing.T) {
+	r, url := setupShareTest(t, 1)
+	if rec := download(r, url, "192.0.2.2", "bytes=0-49"); rec.Code != http.StatusPartialContent {
+		t.Fatalf("first half got %d", rec.Code)
+	}
+	rec := download(r, url, "192.0.2.2", "bytes=50-")
+	if rec.Code != http.StatusPartialContent || rec.Body.Len() != 50 || rec.Body.Bytes()[0] != 50 {
+		t.Fatalf("resume got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := download(r, url, "192.0.2.2", "bytes=1-"); rec.Code != http.StatusGone {
+		t.Fatalf("resume after finishing got %d, want 410", rec.Code)
+	}
+}
+
+func TestShareDownloadResumeFromAnotherIP(t *testing.T) {
+	r, url := setupShareTest(t, 1)
+	if rec := download(r, url, "192.0.2.3", "bytes=0-49"); rec.Code != http.StatusPartialContent {
+		t.Fatalf("first half got %d", rec.Code)
+	}
+	if rec := download(r, url, "198.51.100.7", "bytes=50-"); rec.Code != http.StatusGone {
+		t.Fatalf("resume from another IP got %d, want 410", rec.Code)
+	}
+}
+
+func TestShareDownloadRanges(t *testing.T) {
+	r, url := setupShareTest(t, 0)
+	for _, header := range []string{"bytes=1-100,0-", "bytes=-50", "bytes=10-5", "items=0-", "bytes=a-"} {
+		if rec := download(r, url, "192.0.2.4", header); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("%q got %d, want 416", header, rec.Code)
+		}
+	}
+	// a range that doesn't start at 0 without an unfinished download is counted as a new one
+	if rec := download(r, url, "192.0.2.4", "bytes=1-"); rec.Code != http.StatusPartialContent {
+		t.Fatalf("unlimited link range request got %d", rec.Code)
+	}
+	var link files.ShareLink
+	if err := files.DB.First(&link).Error; err != nil {
+		t.Fatal(err)
+	}
+	if link.Downloads != 1 {
+		t.Fatalf("got %d downloads counted, want 1", link.Downloads)
+	}
+}
@@ -167,7 +167,8 @@ func (s *Server) Start() {
 		}
 
 		// Shutdown signal with grace period of 30 seconds
-		shutdownCtx, _ := context.WithTimeout(serverCtx, ShutdownTimeout)
+		shutdownCtx, cancelShutdown := context.WithTimeout(serverCtx, ShutdownTimeout)
+		defer cancelShutdown()
 
 		go func() {
 			<-shutdownCtx.Done()